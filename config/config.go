@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -9,16 +11,10 @@ import (
 
 type Config struct {
 	Server struct {
-		Port int `yaml:"port"`
+		Port       int    `yaml:"port"`
+		AdminToken string `yaml:"adminToken"`
 	} `yaml:"server"`
-	S3 struct {
-		Url            string `yaml:"url"`
-		AccessKey      string `yaml:"accessKey"`
-		SecretKey      string `yaml:"secretKey"`
-		Region         string `yaml:"region"`
-		MaxConnections int    `yaml:"maxConnections"`
-		Bucket         string `yaml:"bucketName"`
-	} `yaml:"s3"`
+	S3          S3Config `yaml:"s3"`
 	FiberConfig struct {
 		Prefork       bool          `yaml:"prefork"`
 		CaseSensitive bool          `yaml:"case_sensitive"`
@@ -29,6 +25,184 @@ type Config struct {
 		BodyLimit     int           `yaml:"body_limit"`
 		Port          string        `yaml:"port"`
 	} `yaml:"fiber"`
+	Uploader            UploaderConfig               `yaml:"uploader"`
+	ContentRepositories map[string]ContentRepository `yaml:"contentRepositories"`
+}
+
+// S3Config describes an S3 endpoint and its credentials
+type S3Config struct {
+	Url            string `yaml:"url"`
+	AccessKey      string `yaml:"accessKey"`
+	SecretKey      string `yaml:"secretKey"`
+	Region         string `yaml:"region"`
+	MaxConnections int    `yaml:"maxConnections"`
+	Bucket         string `yaml:"bucketName"`
+}
+
+// withDefaults returns a copy of the endpoint settings with unset fields taken from def
+func (s *S3Config) withDefaults(def S3Config) *S3Config {
+	merged := *s
+	if merged.Url == "" {
+		merged.Url = def.Url
+	}
+	if merged.AccessKey == "" && merged.SecretKey == "" {
+		merged.AccessKey, merged.SecretKey = def.AccessKey, def.SecretKey
+	}
+	if merged.Region == "" {
+		merged.Region = def.Region
+	}
+	if merged.MaxConnections == 0 {
+		merged.MaxConnections = def.MaxConnections
+	}
+	return &merged
+}
+
+// Limits and defaults of the S3 multipart uploader, as in the AWS SDK
+const (
+	MinUploadPartSize        = 5 << 20 // smallest part S3 accepts
+	MaxUploadParts           = 10000   // most parts S3 accepts for one object
+	DefaultUploadPartSize    = 5 << 20
+	DefaultUploadConcurrency = 5
+)
+
+// DefaultUploadExpiry is how long a resumable upload stays open unless a repository sets uploadExpiry
+const DefaultUploadExpiry = 24 * time.Hour
+
+// UploaderConfig tunes the S3 multipart uploader. An upload holds up to PartSize ×
+// Concurrency bytes of part buffers in memory.
+type UploaderConfig struct {
+	PartSize          int64 `yaml:"partSize" json:"partSize"`                   // bytes per part, at least 5 MiB
+	Concurrency       int   `yaml:"concurrency" json:"concurrency"`             // parts uploaded in parallel per upload
+	LeavePartsOnError *bool `yaml:"leavePartsOnError" json:"leavePartsOnError"` // keep the parts of failed uploads instead of aborting them
+	BufferPoolSize    int   `yaml:"bufferPoolSize" json:"bufferPoolSize"`       // bytes of pooled buffer for seekable bodies, 0 for none
+	MaxUploadParts    int32 `yaml:"maxUploadParts" json:"maxUploadParts"`       // the part size grows so an upload stays within this many parts
+}
+
+// defaultUploader holds the uploader settings used when neither the repository nor the
+// global uploader section sets them
+var defaultUploader = UploaderConfig{
+	PartSize:          DefaultUploadPartSize,
+	Concurrency:       DefaultUploadConcurrency,
+	LeavePartsOnError: new(bool),
+	MaxUploadParts:    MaxUploadParts,
+}
+
+// withDefaults returns a copy of the uploader settings with unset fields taken from def
+func (u *UploaderConfig) withDefaults(def UploaderConfig) *UploaderConfig {
+	var merged UploaderConfig
+	if u != nil {
+		merged = *u
+	}
+	if merged.PartSize == 0 {
+		merged.PartSize = def.PartSize
+	}
+	if merged.Concurrency == 0 {
+		merged.Concurrency = def.Concurrency
+	}
+	if merged.LeavePartsOnError == nil {
+		merged.LeavePartsOnError = def.LeavePartsOnError
+	}
+	if merged.BufferPoolSize == 0 {
+		merged.BufferPoolSize = def.BufferPoolSize
+	}
+	if merged.MaxUploadParts == 0 {
+		merged.MaxUploadParts = def.MaxUploadParts
+	}
+	return &merged
+}
+
+// Validate checks the uploader settings against the S3 multipart upload limits
+func (u *UploaderConfig) Validate() error {
+	switch {
+	case u.PartSize < MinUploadPartSize:
+		return fmt.Errorf("uploader partSize %d is below the S3 minimum of %d bytes", u.PartSize, MinUploadPartSize)
+	case u.Concurrency < 1:
+		return fmt.Errorf("uploader concurrency %d must be at least 1", u.Concurrency)
+	case u.BufferPoolSize < 0:
+		return fmt.Errorf("uploader bufferPoolSize %d must not be negative", u.BufferPoolSize)
+	case u.MaxUploadParts < 1 || u.MaxUploadParts > MaxUploadParts:
+		return fmt.Errorf("uploader maxUploadParts %d must be between 1 and %d", u.MaxUploadParts, MaxUploadParts)
+	}
+	return nil
+}
+
+// Create modes controlling what happens when a create targets an existing document
+const (
+	CreateModeConditional = "conditional" // S3 If-None-Match conditional write, HeadObject fallback
+	CreateModeHead        = "head"        // HeadObject existence check before the upload
+	CreateModeOverwrite   = "overwrite"   // replace existing documents
+)
+
+// Content repository states
+const (
+	RepoStateOnline   = "online"    // all commands allowed
+	RepoStateReadOnly = "read-only" // reads only, by configuration
+	RepoStateLocked   = "locked"    // reads only, e.g. during a storage migration
+	RepoStateOffline  = "offline"   // no document commands
+)
+
+// DocID case policies of a content repository
+const (
+	DocIDCaseUpper       = "upper"       // docIds are upper-cased
+	DocIDCasePreserve    = "preserve"    // docIds are used as sent, lookups are case-sensitive
+	DocIDCaseInsensitive = "insensitive" // keys use the upper-cased docId, attributes keep the spelling sent on create
+)
+
+// IsValidRepoState reports whether a value is a known content repository state
+func IsValidRepoState(state string) bool {
+	switch state {
+	case RepoStateOnline, RepoStateReadOnly, RepoStateLocked, RepoStateOffline:
+		return true
+	}
+	return false
+}
+
+// ContentRepository holds the settings of one content repository (contRep): where its
+// documents are stored and the policies applied to them
+type ContentRepository struct {
+	Description      string          `yaml:"description"`
+	Bucket           string          `yaml:"bucket"`       // defaults to the contRep name
+	Prefix           string          `yaml:"prefix"`       // key prefix of all objects of the repository
+	S3               *S3Config       `yaml:"s3"`           // distinct endpoint/credentials, defaults to the global s3 section
+	StorageClass     string          `yaml:"storageClass"` // S3 storage class of uploaded components
+	Uploader         *UploaderConfig `yaml:"uploader"`     // multipart uploader tuning, defaults to the global uploader section
+	AllowedTags      []string        `yaml:"allowedTags"`  // extra tags clients may set with X-tag-* headers, "*" for any
+	DocIDCase        string          `yaml:"docIdCase"`    // upper | preserve | insensitive
+	State            string          `yaml:"state"`
+	CreateMode       string          `yaml:"createMode"`
+	RequireSignature bool            `yaml:"requireSignature"`
+	UploadExpiry     time.Duration   `yaml:"uploadExpiry"` // how long a resumable upload stays open
+}
+
+// ContentRepository returns the settings of a configured content repository, filling in
+// defaults, and whether the repository is configured at all
+func (c *Config) ContentRepository(contRep string) (ContentRepository, bool) {
+	repo, ok := c.ContentRepositories[contRep]
+	if repo.Bucket == "" {
+		repo.Bucket = contRep
+	}
+	if repo.Prefix != "" && !strings.HasSuffix(repo.Prefix, "/") {
+		repo.Prefix += "/"
+	}
+	if repo.S3 == nil {
+		repo.S3 = &c.S3
+	} else {
+		repo.S3 = repo.S3.withDefaults(c.S3)
+	}
+	repo.Uploader = repo.Uploader.withDefaults(*c.Uploader.withDefaults(defaultUploader))
+	if repo.State == "" {
+		repo.State = RepoStateOnline
+	}
+	if repo.CreateMode == "" {
+		repo.CreateMode = CreateModeConditional
+	}
+	if repo.DocIDCase == "" {
+		repo.DocIDCase = DocIDCaseUpper
+	}
+	if repo.UploadExpiry == 0 {
+		repo.UploadExpiry = DefaultUploadExpiry
+	}
+	return repo, ok
 }
 
 func GetConfig() (*Config, error) {
@@ -43,6 +217,11 @@ func GetConfig() (*Config, error) {
 		return nil, err
 	}
 
+	// The admin token is a secret, so it can be kept out of the config file
+	if token := os.Getenv("ADMIN_TOKEN"); token != "" {
+		config.Server.AdminToken = token
+	}
+
 	return &config, nil
 }
 
@@ -57,6 +236,7 @@ type TestConfig struct {
 		MaxConnections    int    `yaml:"maxConnections"`
 		Bucket            string `yaml:"bucketName"`
 	} `yaml:"s3"`
+	AdminToken string `yaml:"adminToken"`
 }
 
 func GetTestConfig() (*TestConfig, error) {
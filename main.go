@@ -24,8 +24,13 @@ var (
 )
 
 func main() {
-	// Create S3 client
+	// Create S3 client and resolve the configured content repositories
 	var s3Client = utils.CreateS3Client()
+	utils.InitRepositories(s3Client)
+
+	// Remove resumable uploads that were neither completed nor aborted in time
+	cleanupCtx, stopCleanup := context.WithCancel(context.Background())
+	go utils.RunUploadCleanup(cleanupCtx)
 
 	//Fiber configuration
 	app := utils.CreateNewFiberAppInstance()
@@ -37,48 +42,83 @@ func main() {
 		requestID := uuid.New().String()
 		c.Locals("requestID", requestID) //  generate a UUID for each request
 		c.Set("X-Request-ID", requestID)
-		atomic.AddInt32(&activeRequests, 1)        // increment active request count
-		defer atomic.AddInt32(&activeRequests, -1) // decrement when finished
+		atomic.AddInt32(&activeRequests, 1) // increment active request count
 
 		// Create a context with cancel for this request
 		ctx, cancel := context.WithCancel(context.Background())
 		requestCtxs.Store(requestID, cancel) // store cancel function
-		defer requestCtxs.Delete(requestID)  // remove from map when done
+
+		// The request ends when the handler returns, or when a streamed response is closed
+		defer utils.TrackRequest(c, func() {
+			requestCtxs.Delete(requestID)        // remove from map when done
+			cancel()                             // release the context
+			atomic.AddInt32(&activeRequests, -1) // decrement when finished
+		})()
 
 		c.Locals("ctx", ctx) // attach context to request
 		return c.Next()
 	})
 
+	// Reject request bodies above the body limit, which streamed bodies are not checked against
+	app.Use(utils.EnforceBodyLimit())
+
 	// Routes
 	app.Get("/mem", utils.HandleMem())
+	app.Get("/diagnostics", utils.HandleDiagnostics())
 	app.Get("/docs/*", swagger.HandlerDefault)
 
+	// Validate the ArchiveLink protocol version and the commands it offers
+	app.Use(contentRoute, utils.NegotiatePVersion())
+
+	// Resolve contRep to a configured content repository, unknown ones are rejected
+	app.Use(contentRoute, utils.ResolveRepository())
+
+	// Verify SAP signed URLs (secKey) before any content command runs
+	app.Use(contentRoute, utils.VerifySecKey())
+
+	// Refuse commands the state of the content repository does not allow
+	app.Use(contentRoute, utils.EnforceRepositoryState())
+
+	// Validate docId/compId, derive the docId used in S3 keys and keep the reserved key
+	// prefixes out of reach of document commands
+	app.Use(contentRoute, utils.NormalizeDocID())
+
+	// Commands are dispatched on utils.Command, the same command the protocol version,
+	// secKey and repository state checks above were made for
 	app.Get(contentRoute, func(c *fiber.Ctx) error {
 		ctx := c.Locals("ctx").(context.Context)
-		bucketName := c.Query("contRep")
-		if bucketName == "" {
-			return c.Status(fiber.StatusBadRequest).SendString("missing contRep")
-		}
 
-		q := c.Queries()
-		_, isGet := q["get"]
-		_, isInfo := q["info"]
-		_, isList := q["list"]
-		_, isServerInfo := q["serverInfo"]
+		// serverInfo describes all repositories when no contRep is given
+		cmd := utils.Command(c)
+		if cmd == "serverInfo" {
+			return utils.HandleServerInfo(ctx)(c)
+		}
 
-		if (isGet || isInfo) && c.Query("docId") == "" {
+		repo := utils.CurrentRepository(c)
+		if repo == nil {
+			return c.Status(fiber.StatusBadRequest).SendString("missing contRep")
+		}
+		if utils.IsDocumentCommand(cmd) && utils.DocID(c) == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing docId")
 		}
 
-		switch {
-		case isGet:
-			return utils.HandleGetWithCtx(ctx, s3Client, bucketName)(c)
-		case isInfo:
-			return utils.HandleInfoWithCtx(ctx, s3Client, bucketName)(c)
-		case isList:
-			return utils.HandleListWithCtx(ctx, s3Client, bucketName)(c)
-		case isServerInfo:
-			return utils.HandleServerInfo()(c)
+		switch cmd {
+		case "get":
+			return utils.HandleGetWithCtx(ctx, repo)(c)
+		case "docGet":
+			return utils.HandleDocGetWithCtx(ctx, repo)(c)
+		case "info":
+			return utils.HandleInfoWithCtx(ctx, repo)(c)
+		case "list":
+			return utils.HandleListWithCtx(ctx, repo)(c)
+		case "search":
+			return utils.HandleSearchWithCtx(ctx, repo)(c)
+		case "attrSearch":
+			return utils.HandleAttrSearchWithCtx(ctx, repo)(c)
+		case "getTags":
+			return utils.HandleGetTagsWithCtx(ctx, repo)(c)
+		case "uploadStatus":
+			return utils.HandleUploadStatusWithCtx(ctx, repo)(c)
 		default:
 			return c.Status(fiber.StatusBadRequest).SendString("unknown action")
 		}
@@ -86,26 +126,84 @@ func main() {
 
 	app.Post(contentRoute, func(c *fiber.Ctx) error {
 		ctx := c.Locals("ctx").(context.Context)
-		bucketName := c.Query("contRep")
-		if bucketName == "" {
+		repo := utils.CurrentRepository(c)
+		if repo == nil {
 			return c.Status(fiber.StatusBadRequest).SendString("missing contRep")
 		}
-		if c.Query("docId") == "" {
+
+		cmd := utils.Command(c)
+		if utils.IsDocumentCommand(cmd) && utils.DocID(c) == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing docId")
 		}
-		return utils.HandleCreateWithCtx(ctx, s3Client, bucketName)(c)
+
+		switch cmd {
+		case "mCreate":
+			return utils.HandleMCreateWithCtx(ctx, repo)(c)
+		case "initUpload":
+			return utils.HandleInitUploadWithCtx(ctx, repo)(c)
+		default:
+			return utils.HandleCreateWithCtx(ctx, repo)(c)
+		}
+	})
+
+	app.Put(contentRoute, func(c *fiber.Ctx) error {
+		ctx := c.Locals("ctx").(context.Context)
+		repo := utils.CurrentRepository(c)
+		if repo == nil {
+			return c.Status(fiber.StatusBadRequest).SendString("missing contRep")
+		}
+
+		cmd := utils.Command(c)
+		if utils.IsDocumentCommand(cmd) && utils.DocID(c) == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("missing docId")
+		}
+
+		switch cmd {
+		case "putCert":
+			return utils.HandlePutCertWithCtx(ctx, repo)(c)
+		case "activateCert":
+			return utils.HandleSetCertActiveWithCtx(ctx, repo, true)(c)
+		case "deactivateCert":
+			return utils.HandleSetCertActiveWithCtx(ctx, repo, false)(c)
+		case "adminContRep":
+			return utils.HandleAdminContRepWithCtx(ctx, repo)(c)
+		case "migrateDocIds":
+			return utils.HandleMigrateDocIDsWithCtx(ctx, repo)(c)
+		case "setTags":
+			return utils.HandleSetTagsWithCtx(ctx, repo)(c)
+		case "uploadChunk":
+			return utils.HandleUploadChunkWithCtx(ctx, repo)(c)
+		case "completeUpload":
+			return utils.HandleCompleteUploadWithCtx(ctx, repo)(c)
+		case "create":
+			return utils.HandleCreateWithCtx(ctx, repo)(c)
+		case "update":
+			return utils.HandleUpdateWithCtx(ctx, repo)(c)
+		case "append":
+			return utils.HandleAppendWithCtx(ctx, repo)(c)
+		default:
+			return c.Status(fiber.StatusBadRequest).SendString("unknown action")
+		}
 	})
 
 	app.Delete(contentRoute, func(c *fiber.Ctx) error {
 		ctx := c.Locals("ctx").(context.Context)
-		bucketName := c.Query("contRep")
-		if bucketName == "" {
+		repo := utils.CurrentRepository(c)
+		if repo == nil {
 			return c.Status(fiber.StatusBadRequest).SendString("missing contRep")
 		}
-		if c.Query("docId") == "" {
+
+		cmd := utils.Command(c)
+		if utils.IsDocumentCommand(cmd) && utils.DocID(c) == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("missing docId")
 		}
-		return utils.HandleDeleteWithCtx(ctx, s3Client, bucketName)(c)
+
+		switch cmd {
+		case "abortUpload":
+			return utils.HandleAbortUploadWithCtx(ctx, repo)(c)
+		default:
+			return utils.HandleDeleteWithCtx(ctx, repo)(c)
+		}
 	})
 
 	// Channel to listen for OS termination signals
@@ -123,6 +221,7 @@ func main() {
 	// Wait for termination signal
 	<-quit
 	log.Println("Graceful shutdown initiated...")
+	stopCleanup()
 
 	// Stop accepting new connections, allow max 30s for active connections
 	ctxTimeout, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// setRepositoryState changes the state of the test repository with adminContRep
+func setRepositoryState(t *testing.T, state string) {
+	t.Helper()
+
+	resp, err := client.Do(newAdminRequest("PUT", baseURL+"?adminContRep&contRep="+testBucket+"&state="+state, nil))
+	if err != nil {
+		t.Fatalf("adminContRep request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("adminContRep returned status %d", resp.StatusCode)
+	}
+}
+
+// TestAdminTokenRequired verifies that admin commands are refused without the admin token
+func TestAdminTokenRequired(t *testing.T) {
+	for _, query := range []string{
+		"?adminContRep&contRep=" + testBucket + "&state=offline",
+		"?migrateDocIds&contRep=" + testBucket + "&dryRun=y",
+		"?activateCert&contRep=" + testBucket + "&authId=CN=TEST-SYSTEM",
+	} {
+		req, _ := http.NewRequest("PUT", baseURL+query, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatalf("Expected 403 for %s without admin token, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+// TestLockedRepository verifies that a locked repository refuses writes but serves reads
+func TestLockedRepository(t *testing.T) {
+	docID := "TEST-LOCKED"
+	uploadComponent(t, docID, "data", "LOCKED CONTENT")
+	defer func() {
+		setRepositoryState(t, "online")
+		deleteDocument(t, docID)
+	}()
+
+	setRepositoryState(t, "locked")
+
+	// ---- Reads keep working ----
+	resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for get on locked repository, got %d", resp.StatusCode)
+	}
+
+	// ---- Writes are refused ----
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "data.txt")
+	part.Write([]byte("NEW CONTENT"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId=TEST-LOCKED-NEW", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for create on locked repository, got %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("DELETE", baseURL+"?contRep="+testBucket+"&docId="+docID, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Delete request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for delete on locked repository, got %d", resp.StatusCode)
+	}
+
+	// ---- Migrations are writes too ----
+	resp, err = client.Do(newAdminRequest("PUT", baseURL+"?migrateDocIds&contRep="+testBucket, nil))
+	if err != nil {
+		t.Fatalf("migrateDocIds request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for migrateDocIds on locked repository, got %d", resp.StatusCode)
+	}
+
+	// ---- So are certificate registrations ----
+	cert, err := os.Open("test_signer_cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to open certificate: %v", err)
+	}
+	defer cert.Close()
+	req, _ = http.NewRequest("PUT", baseURL+"?putCert&contRep="+testBucket+"&authId=CN=TEST-LOCKED", cert)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("putCert request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 for putCert on locked repository, got %d", resp.StatusCode)
+	}
+}
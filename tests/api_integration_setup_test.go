@@ -2,7 +2,9 @@ package tests
 
 import (
 	"context"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"testing"
 
@@ -17,11 +19,22 @@ const testBucket = "test-bucket"
 
 var s3Client *s3.Client
 
+// adminToken is the X-Admin-Token the server under test accepts for admin commands
+var adminToken string
+
+// newAdminRequest returns a request carrying the admin token
+func newAdminRequest(method, target string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, target, body)
+	req.Header.Set("X-Admin-Token", adminToken)
+	return req
+}
+
 func TestMain(m *testing.M) {
 	testCfg, err := testconfig.GetTestConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	adminToken = testCfg.AdminToken
 	// Load AWS SDK config to connect to MinIO
 	cfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(testCfg.S3.Region),
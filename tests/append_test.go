@@ -0,0 +1,54 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestAppend verifies that appended data is added to the end of an existing component
+func TestAppend(t *testing.T) {
+	docID := "TEST-APPEND"
+	uploadComponent(t, docID, "data", "FIRST LINE\n")
+	defer deleteDocument(t, docID)
+
+	req, err := http.NewRequest("PUT", baseURL+"?append&contRep="+testBucket+"&docId="+docID+"&compId=data", strings.NewReader("SECOND LINE\n"))
+	if err != nil {
+		t.Fatalf("Append request creation failed: %v", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Append request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Append returned status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=data")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	downloaded, _ := io.ReadAll(resp.Body)
+	if string(downloaded) != "FIRST LINE\nSECOND LINE\n" {
+		t.Fatalf("Appended content mismatch: got %q", string(downloaded))
+	}
+}
+
+// TestAppendNotFound verifies that appending to a missing component returns 404
+func TestAppendNotFound(t *testing.T) {
+	req, _ := http.NewRequest("PUT", baseURL+"?append&contRep="+testBucket+"&docId=NO_SUCH_FILE_123", strings.NewReader("DATA"))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Append request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 Not Found, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// TestAttrSearch verifies attribute search over the descriptor component
+func TestAttrSearch(t *testing.T) {
+	docID := "TEST-ATTRSEARCH"
+	uploadComponent(t, docID, "data", "print list content")
+	uploadComponent(t, docID, "descr", "0;100;customer=4711;type=INVOICE\n100;50;customer=4712;type=CREDIT\n150;70;customer=4711;type=CREDIT\n")
+	defer deleteDocument(t, docID)
+
+	cases := []struct {
+		pattern string
+		query   string
+		result  string
+	}{
+		{"customer=4711", "", "2;0;100;150;70;"},
+		{"customer=4711&type=CRED*", "", "1;150;70;"},
+		{"type=invoice|customer=4712", "&caseSensitive=n", "2;0;100;100;50;"},
+		{"customer=4711", "&fromOffset=1", "1;150;70;"},
+	}
+
+	for _, tc := range cases {
+		resp, err := client.Get(baseURL + "?attrSearch&contRep=" + testBucket + "&docId=" + docID + "&pattern=" + url.QueryEscape(tc.pattern) + tc.query)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tc.pattern, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: attrSearch returned status %d", tc.pattern, resp.StatusCode)
+		}
+		if string(body) != tc.result {
+			t.Fatalf("%s: expected %q, got %q", tc.pattern, tc.result, string(body))
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// certState returns whether the certificate of an authId is listed in serverInfo and active
+func certState(t *testing.T, authID string) (bool, bool) {
+	t.Helper()
+
+	req, _ := http.NewRequest("GET", baseURL+"?serverInfo&contRep="+testBucket, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("serverInfo request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Repositories []struct {
+			Certificates []struct {
+				AuthID string `json:"authId"`
+				Active bool   `json:"active"`
+			} `json:"certificates"`
+		} `json:"repositories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("Decoding serverInfo failed: %v", err)
+	}
+	for _, repo := range info.Repositories {
+		for _, cert := range repo.Certificates {
+			if cert.AuthID == authID {
+				return true, cert.Active
+			}
+		}
+	}
+	return false, false
+}
+
+// TestPutCertTooLarge verifies that a chunked putCert body is limited while it is read
+func TestPutCertTooLarge(t *testing.T) {
+	body := io.MultiReader(bytes.NewReader(bytes.Repeat([]byte("A"), 128<<10)))
+	req, _ := http.NewRequest("PUT", baseURL+"?putCert&contRep="+testBucket+"&authId=CN=TOO-LARGE", body)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("putCert request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected 413 for an oversized certificate, got %d", resp.StatusCode)
+	}
+}
+
+// TestPutCert verifies that a registered certificate is listed and can be activated
+func TestPutCert(t *testing.T) {
+	authID := "CN=TEST-SYSTEM"
+
+	cert, err := os.Open("test_cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to open test certificate: %v", err)
+	}
+	defer cert.Close()
+
+	req, _ := http.NewRequest("PUT", baseURL+"?putCert&contRep="+testBucket+"&authId="+authID, cert)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("putCert request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("putCert returned status %d", resp.StatusCode)
+	}
+
+	if found, active := certState(t, authID); !found || active {
+		t.Fatalf("Expected inactive certificate, got found=%t active=%t", found, active)
+	}
+
+	resp, err = client.Do(newAdminRequest("PUT", baseURL+"?activateCert&contRep="+testBucket+"&authId="+authID, nil))
+	if err != nil {
+		t.Fatalf("activateCert request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("activateCert returned status %d", resp.StatusCode)
+	}
+
+	if found, active := certState(t, authID); !found || !active {
+		t.Fatalf("Expected active certificate, got found=%t active=%t", found, active)
+	}
+
+	// ---- Only an admin or the owner replaces a registered certificate ----
+	data, err := os.ReadFile("test_cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to read test certificate: %v", err)
+	}
+
+	req, _ = http.NewRequest("PUT", baseURL+"?putCert&contRep="+testBucket+"&authId="+authID, bytes.NewReader(data))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("putCert request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected 403 replacing a certificate without admin token, got %d", resp.StatusCode)
+	}
+	if _, active := certState(t, authID); !active {
+		t.Fatalf("Refused replacement deactivated the certificate")
+	}
+
+	resp, err = client.Do(newAdminRequest("PUT", baseURL+"?putCert&contRep="+testBucket+"&authId="+authID, bytes.NewReader(data)))
+	if err != nil {
+		t.Fatalf("putCert request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 replacing a certificate with admin token, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,197 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// uploadComponent creates a single component of a document with the given content
+func uploadComponent(t *testing.T, docID, compID, content string) {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, err := writer.CreateFormFile("file", compID+".txt")
+	if err != nil {
+		t.Fatalf("Failed to create multipart form: %v", err)
+	}
+	if _, err := fileWriter.Write([]byte(content)); err != nil {
+		t.Fatalf("Failed to write multipart form: %v", err)
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID+"&compId="+compID, &body)
+	if err != nil {
+		t.Fatalf("Upload request creation failed: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload of %s/%s returned status %d", docID, compID, resp.StatusCode)
+	}
+}
+
+// deleteDocument removes a document left behind by a test, ignoring any failure
+func deleteDocument(t *testing.T, docID string) {
+	t.Helper()
+
+	req, _ := http.NewRequest("DELETE", baseURL+"?contRep="+testBucket+"&docId="+docID, nil)
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+	}
+}
+
+// TestComponents verifies that components of one document are stored and served independently
+func TestComponents(t *testing.T) {
+	docID := "TEST-COMPONENTS"
+
+	uploadComponent(t, docID, "data", "DATA COMPONENT")
+	uploadComponent(t, docID, "note", "NOTE COMPONENT")
+
+	// ---- Get single component ----
+	resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=note")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Download returned status %d", resp.StatusCode)
+	}
+	downloaded, _ := io.ReadAll(resp.Body)
+	if string(downloaded) != "NOTE COMPONENT" {
+		t.Fatalf("Component content mismatch: got %q", string(downloaded))
+	}
+
+	// ---- Info lists all components ----
+	req, _ := http.NewRequest("GET", baseURL+"?info&contRep="+testBucket+"&docId="+docID, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Info request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		NumberComps int `json:"numberComps"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("Decoding info failed: %v", err)
+	}
+	if info.NumberComps != 2 {
+		t.Fatalf("Expected 2 components, got %d", info.NumberComps)
+	}
+
+	// ---- Delete a missing component ----
+	req, _ = http.NewRequest("DELETE", baseURL+"?contRep="+testBucket+"&docId="+docID+"&compId=missing", nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Delete request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 for a missing component, got %d", resp.StatusCode)
+	}
+
+	// ---- Delete whole document ----
+	req, _ = http.NewRequest("DELETE", baseURL+"?contRep="+testBucket+"&docId="+docID, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Delete request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Delete returned status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?info&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Info request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 after delete, got %d", resp.StatusCode)
+	}
+}
+
+// TestLegacyDocument verifies that a document stored under its bare docId, as documents were
+// stored before they had components, is still served as its data component
+func TestLegacyDocument(t *testing.T) {
+	docID := "TEST-LEGACY"
+	defer deleteDocument(t, docID)
+
+	_, err := s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket:   aws.String(testBucket),
+		Key:      aws.String(docID),
+		Body:     strings.NewReader("LEGACY CONTENT"),
+		Metadata: map[string]string{"filename": "legacy.txt"},
+	})
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	// ---- get serves the legacy object ----
+	resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(content) != "LEGACY CONTENT" {
+		t.Fatalf("Expected the legacy document, got status %d body %q", resp.StatusCode, content)
+	}
+
+	// ---- info lists it as the data component ----
+	req, _ := http.NewRequest("GET", baseURL+"?info&contRep="+testBucket+"&docId="+docID, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Info request failed: %v", err)
+	}
+	var info struct {
+		Components []struct {
+			CompID string `json:"compId"`
+		} `json:"components"`
+	}
+	json.NewDecoder(resp.Body).Decode(&info)
+	resp.Body.Close()
+	if len(info.Components) != 1 || info.Components[0].CompID != "data" {
+		t.Fatalf("Expected a single data component, got %+v", info.Components)
+	}
+
+	// ---- delete removes the legacy object ----
+	req, _ = http.NewRequest("DELETE", baseURL+"?contRep="+testBucket+"&docId="+docID, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Delete request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Delete returned status %d", resp.StatusCode)
+	}
+	_, err = s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(docID),
+	})
+	if err == nil {
+		t.Fatalf("Legacy object still exists after delete")
+	}
+}
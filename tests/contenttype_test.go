@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+)
+
+// TestContentType verifies that declared and sniffed content types are stored and served
+func TestContentType(t *testing.T) {
+	docID := "TEST-CONTENT-TYPE"
+	defer deleteDocument(t, docID)
+
+	// ---- Declared type with charset and version ----
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", `form-data; name="file"; filename="note.txt"`)
+	header.Set("Content-Type", "text/plain; charset=ISO-8859-1")
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		t.Fatalf("Failed to create multipart form: %v", err)
+	}
+	part.Write([]byte("caf\xe9"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID+"&compId=note&version=0.1", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=note")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=ISO-8859-1; version=0.1" {
+		t.Fatalf("Unexpected Content-Type %q", ct)
+	}
+
+	// ---- Sniffed type ----
+	uploadComponent(t, docID, "data", "%PDF-1.4 minimal")
+
+	req, _ = http.NewRequest("GET", baseURL+"?info&contRep="+testBucket+"&docId="+docID, nil)
+	req.Header.Set("Accept", "application/json")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Info request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Components []struct {
+			CompID      string `json:"compId"`
+			ContentType string `json:"contentType"`
+			Charset     string `json:"charset"`
+			Version     string `json:"version"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatalf("Decoding info failed: %v", err)
+	}
+	for _, comp := range info.Components {
+		switch comp.CompID {
+		case "data":
+			if comp.ContentType != "application/pdf" {
+				t.Fatalf("Expected sniffed application/pdf, got %q", comp.ContentType)
+			}
+		case "note":
+			if comp.Charset != "ISO-8859-1" || comp.Version != "0.1" {
+				t.Fatalf("Unexpected charset/version %q/%q", comp.Charset, comp.Version)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"testing"
+)
+
+// TestDocGet verifies that docGet returns every component as a multipart part
+func TestDocGet(t *testing.T) {
+	docID := "TEST-DOCGET"
+	expected := map[string]string{
+		"data": "DATA COMPONENT",
+		"note": "NOTE COMPONENT",
+	}
+	for compID, content := range expected {
+		uploadComponent(t, docID, compID, content)
+	}
+	defer deleteDocument(t, docID)
+
+	resp, err := client.Get(baseURL + "?docGet&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("docGet request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("docGet returned status %d", resp.StatusCode)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		t.Fatalf("Unexpected Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	found := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reading part failed: %v", err)
+		}
+
+		compID := part.Header.Get("X-compId")
+		data, _ := io.ReadAll(part)
+		if expected[compID] != string(data) {
+			t.Fatalf("Component %q content mismatch: got %q", compID, string(data))
+		}
+		found++
+	}
+
+	if found != len(expected) {
+		t.Fatalf("Expected %d parts, got %d", len(expected), found)
+	}
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestDocIDNormalisation verifies that every command derives the same key from a docId and
+// that docIds breaking the ArchiveLink rules are rejected
+func TestDocIDNormalisation(t *testing.T) {
+	docID := "TEST-DOCID-CASE"
+	uploadComponent(t, strings.ToLower(docID), "data", "CASE CONTENT")
+	defer deleteDocument(t, strings.ToLower(docID))
+
+	// ---- Lower-case create, mixed-case get ----
+	resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=Test-DocId-Case")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(content) != "CASE CONTENT" {
+		t.Fatalf("Expected the lower-case document, got status %d body %q", resp.StatusCode, content)
+	}
+
+	// ---- Invalid docIds and compIds ----
+	for _, query := range []string{
+		"&docId=" + strings.Repeat("X", 41),
+		"&docId=A%2FB",
+		"&docId=..",
+		"&docId=" + docID + "&compId=a%2Fb",
+	} {
+		resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("Expected status 400 for %s, got %d", query, resp.StatusCode)
+		}
+	}
+}
+
+// TestMigrateLegacyDocument verifies that migrateDocIds moves a document stored under its bare
+// docId to its data component
+func TestMigrateLegacyDocument(t *testing.T) {
+	docID := "TEST-MIGRATE-LEGACY"
+	defer deleteDocument(t, docID)
+
+	_, err := s3Client.PutObject(context.TODO(), &s3.PutObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(docID),
+		Body:   strings.NewReader("LEGACY CONTENT"),
+	})
+	if err != nil {
+		t.Fatalf("PutObject failed: %v", err)
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		target := baseURL + "?migrateDocIds&contRep=" + testBucket
+		if dryRun {
+			target += "&dryRun=y"
+		}
+		resp, err := client.Do(newAdminRequest("PUT", target, nil))
+		if err != nil {
+			t.Fatalf("migrateDocIds request failed: %v", err)
+		}
+		var report struct {
+			Migrated []string `json:"migrated"`
+			Invalid  []string `json:"invalid"`
+		}
+		json.NewDecoder(resp.Body).Decode(&report)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("migrateDocIds returned status %d", resp.StatusCode)
+		}
+		if !slices.Contains(report.Migrated, docID+" -> "+docID+"/data") || slices.Contains(report.Invalid, docID) {
+			t.Fatalf("Legacy document not reported as migrated (dryRun=%v): %+v", dryRun, report)
+		}
+	}
+
+	// ---- The document now lives in its data component ----
+	if _, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(docID + "/data"),
+	}); err != nil {
+		t.Fatalf("Migrated component missing: %v", err)
+	}
+	if _, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(docID),
+	}); err == nil {
+		t.Fatalf("Legacy object still exists after migration")
+	}
+}
@@ -1,7 +1,9 @@
 package tests
 
 import (
+	"bytes"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"testing"
 )
@@ -32,3 +34,110 @@ func TestUnknownAction(t *testing.T) {
 		t.Fatalf("Expected status 400, got %d", resp.StatusCode)
 	}
 }
+
+// TestCreateExisting ensures a create never replaces an existing document
+func TestCreateExisting(t *testing.T) {
+	docID := "TEST-CREATE-EXISTING"
+	uploadComponent(t, docID, "data", "ORIGINAL")
+	defer deleteDocument(t, docID)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("file", "data.txt")
+	part.Write([]byte("REPLACEMENT"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID+"&compId=data", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", resp.StatusCode)
+	}
+}
+
+// TestInvalidSecKey ensures signed URLs with an unknown certificate or wrong accessMode are rejected
+func TestInvalidSecKey(t *testing.T) {
+	signed := "&authId=NO_SUCH_AUTH&expiration=20991231235959&secKey=AAAA"
+
+	resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=TEST1&accessMode=r" + signed)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=TEST1&accessMode=d" + signed)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403, got %d", resp.StatusCode)
+	}
+}
+
+// TestPVersion ensures unsupported protocol versions and commands are rejected
+func TestPVersion(t *testing.T) {
+	resp, err := client.Get(baseURL + "?serverInfo&pVersion=0099")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Post(baseURL+"?mCreate&contRep="+testBucket+"&pVersion=0045", "multipart/form-data; boundary=x", nil)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?attrSearch&contRep=" + testBucket + "&docId=TEST&pVersion=0045")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for attrSearch with pVersion 0045, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?serverInfo&pVersion=0046")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.Header.Get("X-pVersion") != "0046" {
+		t.Fatalf("Expected X-pVersion 0046, got %q", resp.Header.Get("X-pVersion"))
+	}
+}
+
+// TestUnknownContRep ensures repositories missing from the configuration cannot be addressed
+func TestUnknownContRep(t *testing.T) {
+	resp, err := client.Get(baseURL + "?get&contRep=no-such-repo&docId=TEST")
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", resp.StatusCode)
+	}
+}
@@ -1,7 +1,11 @@
 package tests
 
 import (
+	"io"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"strings"
 	"testing"
 )
 
@@ -17,3 +21,88 @@ func TestInfoNotFound(t *testing.T) {
 		t.Fatalf("Expected 404 Not Found, got %d", resp.StatusCode)
 	}
 }
+
+// TestInfoArchiveLink verifies the multipart and ASCII ArchiveLink info formats
+func TestInfoArchiveLink(t *testing.T) {
+	docID := "TEST-INFO"
+	uploadComponent(t, docID, "data", "DATA COMPONENT")
+	uploadComponent(t, docID, "note", "NOTE COMPONENT")
+	defer deleteDocument(t, docID)
+
+	// ---- Multipart ----
+	resp, err := client.Get(baseURL + "?info&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Info request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Info returned status %d", resp.StatusCode)
+	}
+	if resp.Header.Get("X-numberComps") != "2" || resp.Header.Get("X-docId") != docID {
+		t.Fatalf("Unexpected document headers: %v", resp.Header)
+	}
+
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("Unexpected Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+	reader := multipart.NewReader(resp.Body, params["boundary"])
+	parts := 0
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reading part failed: %v", err)
+		}
+		if part.Header.Get("X-compId") == "" {
+			t.Fatalf("Part without X-compId: %v", part.Header)
+		}
+		parts++
+	}
+	if parts != 2 {
+		t.Fatalf("Expected 2 parts, got %d", parts)
+	}
+
+	// ---- ASCII ----
+	resp, err = client.Get(baseURL + "?info&contRep=" + testBucket + "&docId=" + docID + "&resultAs=ascii")
+	if err != nil {
+		t.Fatalf("Info request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\r\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `docId="`+docID+`"`) {
+		t.Fatalf("Unexpected ASCII info: %q", string(body))
+	}
+}
+
+// TestInfoPVersion verifies that info only sends the attributes of the negotiated pVersion
+func TestInfoPVersion(t *testing.T) {
+	docID := "TEST-INFO-PVERSION"
+	uploadComponent(t, docID, "data", "DATA COMPONENT")
+	defer deleteDocument(t, docID)
+
+	for pVersion, withStatus := range map[string]bool{"0045": false, "0046": true, "0047": true} {
+		resp, err := client.Get(baseURL + "?info&contRep=" + testBucket + "&docId=" + docID + "&pVersion=" + pVersion + "&resultAs=ascii")
+		if err != nil {
+			t.Fatalf("Info request failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Info with pVersion %s returned status %d", pVersion, resp.StatusCode)
+		}
+		if (resp.Header.Get("X-docStatus") != "") != withStatus || strings.Contains(string(body), "compStatus=") != withStatus {
+			t.Fatalf("pVersion %s: expected status attributes %t, got header %q and body %q",
+				pVersion, withStatus, resp.Header.Get("X-docStatus"), string(body))
+		}
+		if !strings.Contains(string(body), `pVersion="`+pVersion+`"`) {
+			t.Fatalf("pVersion %s: expected the negotiated version in the body, got %q", pVersion, string(body))
+		}
+	}
+}
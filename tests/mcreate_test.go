@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+)
+
+// TestMCreate verifies that several documents can be created with one request
+func TestMCreate(t *testing.T) {
+	docIDs := []string{"TEST-MCREATE-1", "TEST-MCREATE-2"}
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, docID := range docIDs {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+docID+`"; filename="`+docID+`.txt"`)
+		header.Set("Content-Type", "text/plain")
+		header.Set("X-docId", docID)
+		header.Set("X-compId", "data")
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		part.Write([]byte("CONTENT OF " + docID))
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("POST", baseURL+"?mCreate&contRep="+testBucket, &body)
+	if err != nil {
+		t.Fatalf("mCreate request creation failed: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("mCreate request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("mCreate returned status %d", resp.StatusCode)
+	}
+
+	var results []struct {
+		DocID  string `json:"docId"`
+		Status int    `json:"status"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		t.Fatalf("Decoding mCreate response failed: %v", err)
+	}
+	if len(results) != len(docIDs) {
+		t.Fatalf("Expected %d results, got %d", len(docIDs), len(results))
+	}
+
+	for _, docID := range docIDs {
+		req, _ := http.NewRequest("DELETE", baseURL+"?contRep="+testBucket+"&docId="+docID, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Delete request failed: %v", err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Delete of %s returned status %d", docID, resp.StatusCode)
+		}
+	}
+}
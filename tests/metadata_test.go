@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// TestMetadata verifies that document attributes are stored as S3 user metadata and that
+// non-ASCII filenames survive the round trip
+func TestMetadata(t *testing.T) {
+	docID := "TEST-METADATA"
+	filename := "Größe & Maß.txt"
+	defer deleteDocument(t, docID)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create multipart form: %v", err)
+	}
+	fileWriter.Write([]byte("METADATA CONTENT"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	// ---- Stored as user metadata ----
+	head, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(docID + "/data"),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if head.Metadata["docid"] != docID || head.Metadata["datec"] == "" || head.Metadata["filename"] == "" {
+		t.Fatalf("Unexpected user metadata: %v", head.Metadata)
+	}
+
+	// ---- get sends the filename percent-encoded ----
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	disposition := resp.Header.Get("Content-Disposition")
+	if !strings.Contains(disposition, "filename*=UTF-8''Gr%C3%B6%C3%9Fe%20&%20Ma%C3%9F.txt") {
+		t.Fatalf("Unexpected Content-Disposition %q", disposition)
+	}
+
+	// ---- list details ----
+	resp, err = client.Get(baseURL + "?list&contRep=" + testBucket + "&details=y")
+	if err != nil {
+		t.Fatalf("List request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var entries []struct {
+		DocID    string `json:"docId"`
+		Filename string `json:"filename"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		t.Fatalf("Decoding list failed: %v", err)
+	}
+	found := false
+	for _, e := range entries {
+		if e.DocID == docID {
+			found = e.Filename == filename
+		}
+	}
+	if !found {
+		t.Fatalf("Filename %q not listed: %+v", filename, entries)
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// TestPartialGet verifies ranged downloads with fromOffset/toOffset and the Range header
+func TestPartialGet(t *testing.T) {
+	docID := "TEST-RANGE"
+	uploadComponent(t, docID, "data", "0123456789")
+	defer deleteDocument(t, docID)
+
+	getURL := baseURL + "?get&contRep=" + testBucket + "&docId=" + docID
+
+	cases := []struct {
+		name         string
+		query        string
+		rangeHeader  string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"offsets", "&fromOffset=2&toOffset=5", "", http.StatusPartialContent, "2345", "bytes 2-5/10"},
+		{"open offset", "&fromOffset=7", "", http.StatusPartialContent, "789", "bytes 7-9/10"},
+		{"range header", "", "bytes=0-3", http.StatusPartialContent, "0123", "bytes 0-3/10"},
+		{"suffix range", "", "bytes=-2", http.StatusPartialContent, "89", "bytes 8-9/10"},
+		{"unsatisfiable", "", "bytes=20-30", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+		// An invalid Range header is ignored and the whole component is sent (RFC 9110 section 14.2)
+		{"invalid range", "", "bytes=abc-", http.StatusOK, "0123456789", ""},
+		{"reversed range", "", "bytes=5-2", http.StatusOK, "0123456789", ""},
+		{"unknown unit", "", "items=0-3", http.StatusOK, "0123456789", ""},
+	}
+
+	for _, tc := range cases {
+		req, _ := http.NewRequest("GET", getURL+tc.query, nil)
+		if tc.rangeHeader != "" {
+			req.Header.Set("Range", tc.rangeHeader)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tc.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s: expected status %d, got %d", tc.name, tc.status, resp.StatusCode)
+		}
+		if resp.Header.Get("Content-Range") != tc.contentRange {
+			t.Fatalf("%s: expected Content-Range %q, got %q", tc.name, tc.contentRange, resp.Header.Get("Content-Range"))
+		}
+		if tc.body != "" && string(body) != tc.body {
+			t.Fatalf("%s: expected body %q, got %q", tc.name, tc.body, string(body))
+		}
+	}
+}
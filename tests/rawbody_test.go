@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestCreateRawBody verifies that create accepts the content as a plain request body
+func TestCreateRawBody(t *testing.T) {
+	docID := "TEST-RAW-BODY"
+	defer deleteDocument(t, docID)
+
+	// ---- Filename from Content-Disposition ----
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID, strings.NewReader("RAW CONTENT"))
+	req.Header.Set("Content-Type", "text/plain; charset=UTF-8")
+	req.Header.Set("Content-Disposition", `attachment; filename="raw.txt"`)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(content) != "RAW CONTENT" {
+		t.Fatalf("Unexpected content %q", content)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain; charset=UTF-8" {
+		t.Fatalf("Unexpected Content-Type %q", ct)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, `filename="raw.txt"`) {
+		t.Fatalf("Unexpected Content-Disposition %q", cd)
+	}
+
+	// ---- Filename from the query ----
+	req, _ = http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID+"&compId=note&filename=note.pdf",
+		strings.NewReader("%PDF-1.4 raw"))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=note")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/pdf" {
+		t.Fatalf("Expected sniffed application/pdf, got %q", ct)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); !strings.Contains(cd, `filename="note.pdf"`) {
+		t.Fatalf("Unexpected Content-Disposition %q", cd)
+	}
+
+	// ---- ArchiveLink single-component create with PUT ----
+	req, _ = http.NewRequest("PUT", baseURL+"?create&contRep="+testBucket+"&docId="+docID+"&compId=put",
+		strings.NewReader("PUT CONTENT"))
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("PUT create returned status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=put")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	content, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(content) != "PUT CONTENT" {
+		t.Fatalf("Unexpected content %q", content)
+	}
+}
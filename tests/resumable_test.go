@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// uploadStatus is the JSON returned by initUpload and uploadStatus
+type uploadStatus struct {
+	UploadID string `json:"uploadId"`
+	DocID    string `json:"docId"`
+	CompID   string `json:"compId"`
+	Chunks   []struct {
+		Chunk int   `json:"chunk"`
+		Size  int64 `json:"size"`
+	} `json:"chunks"`
+	Size    int64     `json:"size"`
+	Expires time.Time `json:"expires"`
+}
+
+// initUpload starts a resumable upload of the data component of a document
+func initUpload(t *testing.T, docID string, headers map[string]string) uploadStatus {
+	t.Helper()
+
+	req, _ := http.NewRequest("POST", baseURL+"?initUpload&contRep="+testBucket+"&docId="+docID+"&filename=scan.txt", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("initUpload request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("initUpload returned status %d", resp.StatusCode)
+	}
+	var status uploadStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatalf("Decoding initUpload failed: %v", err)
+	}
+	return status
+}
+
+// uploadCommand sends a resumable upload command and returns its status code
+func uploadCommand(t *testing.T, method, command, uploadID string, body []byte) int {
+	t.Helper()
+
+	req, _ := http.NewRequest(method, baseURL+"?"+command+"&contRep="+testBucket+"&uploadId="+uploadID, bytes.NewReader(body))
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s request failed: %v", command, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestResumableUpload verifies chunked uploads, chunk retries, the status query and abort
+func TestResumableUpload(t *testing.T) {
+	docID := "TEST-RESUMABLE"
+	defer deleteDocument(t, docID)
+
+	upload := initUpload(t, docID, map[string]string{"Content-Type": "text/plain", "X-tag-retention": "10y"})
+	if upload.UploadID == "" || upload.DocID != docID || upload.CompID != "data" || !upload.Expires.After(time.Now()) {
+		t.Fatalf("Unexpected initUpload response: %+v", upload)
+	}
+
+	// ---- Chunks out of order, the first one sent twice ----
+	first := bytes.Repeat([]byte("A"), 5<<20)
+	last := []byte("THE END")
+	for _, chunk := range []struct {
+		number int
+		data   []byte
+	}{{2, last}, {1, bytes.Repeat([]byte("X"), 5<<20)}, {1, first}} {
+		status := uploadCommand(t, "PUT", "uploadChunk&chunk="+strconv.Itoa(chunk.number), upload.UploadID, chunk.data)
+		if status != http.StatusOK {
+			t.Fatalf("uploadChunk %d returned status %d", chunk.number, status)
+		}
+	}
+
+	resp, err := client.Get(baseURL + "?uploadStatus&contRep=" + testBucket + "&uploadId=" + upload.UploadID)
+	if err != nil {
+		t.Fatalf("uploadStatus request failed: %v", err)
+	}
+	var status uploadStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if len(status.Chunks) != 2 || status.Size != int64(len(first)+len(last)) {
+		t.Fatalf("Unexpected upload status: %+v", status)
+	}
+
+	if code := uploadCommand(t, "PUT", "completeUpload&chunks=2", upload.UploadID, nil); code != http.StatusOK {
+		t.Fatalf("completeUpload returned status %d", code)
+	}
+
+	// ---- The document matches a normal create ----
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(content, append(first, last...)) {
+		t.Fatalf("Downloaded %d bytes, expected %d", len(content), len(first)+len(last))
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain" {
+		t.Fatalf("Unexpected Content-Type %q", ct)
+	}
+	if tags := getTags(t, docID); tags["retention"] != "10y" || tags["docId"] != docID {
+		t.Fatalf("Unexpected tags: %v", tags)
+	}
+
+	// ---- The session is gone once completed ----
+	if code := uploadCommand(t, "PUT", "completeUpload", upload.UploadID, nil); code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 for a completed upload, got %d", code)
+	}
+
+	// ---- An existing component cannot be uploaded again ----
+	req, _ := http.NewRequest("POST", baseURL+"?initUpload&contRep="+testBucket+"&docId="+docID, nil)
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("initUpload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status 403 for an existing component, got %d", resp.StatusCode)
+	}
+
+	// ---- Missing chunks fail the upload ----
+	gap := initUpload(t, docID+"-GAP", nil)
+	defer uploadCommand(t, "DELETE", "abortUpload", gap.UploadID, nil)
+	for number, data := range map[int][]byte{1: first, 3: last} {
+		if code := uploadCommand(t, "PUT", "uploadChunk&chunk="+strconv.Itoa(number), gap.UploadID, data); code != http.StatusOK {
+			t.Fatalf("uploadChunk %d returned status %d", number, code)
+		}
+	}
+	if code := uploadCommand(t, "PUT", "completeUpload", gap.UploadID, nil); code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a gap between chunks, got %d", code)
+	}
+	if code := uploadCommand(t, "PUT", "uploadChunk&chunk=2", gap.UploadID, first); code != http.StatusOK {
+		t.Fatalf("uploadChunk 2 returned status %d", code)
+	}
+	if code := uploadCommand(t, "PUT", "completeUpload&chunks=4", gap.UploadID, nil); code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a missing last chunk, got %d", code)
+	}
+
+	// ---- Abort ----
+	aborted := initUpload(t, docID+"-ABORT", nil)
+	if code := uploadCommand(t, "PUT", "uploadChunk&chunk=1", aborted.UploadID, last); code != http.StatusOK {
+		t.Fatalf("uploadChunk returned status %d", code)
+	}
+	if code := uploadCommand(t, "DELETE", "abortUpload", aborted.UploadID, nil); code != http.StatusOK {
+		t.Fatalf("abortUpload returned status %d", code)
+	}
+	if code := uploadCommand(t, "GET", "uploadStatus", aborted.UploadID, nil); code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 for an aborted upload, got %d", code)
+	}
+}
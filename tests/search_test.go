@@ -0,0 +1,43 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+// TestSearch verifies pattern search with offsets, case folding and hit limits
+func TestSearch(t *testing.T) {
+	docID := "TEST-SEARCH"
+	uploadComponent(t, docID, "data", "Invoice 1\nINVOICE 2\ninvoice 3\n")
+	defer deleteDocument(t, docID)
+
+	searchURL := baseURL + "?search&contRep=" + testBucket + "&docId=" + docID + "&compId=data&pattern=invoice"
+
+	cases := []struct {
+		name   string
+		query  string
+		result string
+	}{
+		{"case sensitive", "", "1;20;"},
+		{"case insensitive", "&caseSensitive=n", "3;0;10;20;"},
+		{"from offset", "&caseSensitive=n&fromOffset=5", "2;10;20;"},
+		{"max hits", "&caseSensitive=n&numResults=1", "1;0;"},
+	}
+
+	for _, tc := range cases {
+		resp, err := client.Get(searchURL + tc.query)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v", tc.name, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: search returned status %d", tc.name, resp.StatusCode)
+		}
+		if string(body) != tc.result {
+			t.Fatalf("%s: expected %q, got %q", tc.name, tc.result, string(body))
+		}
+	}
+}
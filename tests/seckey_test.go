@@ -0,0 +1,301 @@
+package tests
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/rand"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+)
+
+var (
+	oidData       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSHA1       = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDSA        = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+)
+
+// The PKCS#7 structures of a detached signature without authenticated attributes, as SAP sends them
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// dsaPrivateKey is the OpenSSL encoding of a DSA private key
+type dsaPrivateKey struct {
+	Version       int
+	P, Q, G, Y, X *big.Int
+}
+
+// loadSigner reads the DSA key and certificate the tests sign URLs with
+func loadSigner(t *testing.T) (*dsa.PrivateKey, *x509.Certificate) {
+	t.Helper()
+
+	keyPEM, err := os.ReadFile("test_signer_key.pem")
+	if err != nil {
+		t.Fatalf("Failed to read signer key: %v", err)
+	}
+	block, _ := pem.Decode(keyPEM)
+	var k dsaPrivateKey
+	if block == nil {
+		t.Fatalf("Signer key is not PEM encoded")
+	}
+	if _, err := asn1.Unmarshal(block.Bytes, &k); err != nil {
+		t.Fatalf("Failed to parse signer key: %v", err)
+	}
+	key := &dsa.PrivateKey{
+		PublicKey: dsa.PublicKey{Parameters: dsa.Parameters{P: k.P, Q: k.Q, G: k.G}, Y: k.Y},
+		X:         k.X,
+	}
+
+	certPEM, err := os.ReadFile("test_signer_cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to read signer certificate: %v", err)
+	}
+	block, _ = pem.Decode(certPEM)
+	if block == nil {
+		t.Fatalf("Signer certificate is not PEM encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse signer certificate: %v", err)
+	}
+	return key, cert
+}
+
+// digestOIDs maps the digest algorithms the tests sign with to their identifiers
+var digestOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   oidSHA1,
+	crypto.SHA256: oidSHA256,
+}
+
+// signPKCS7 returns a detached PKCS#7 DSA signature over content with the given digest
+func signPKCS7(t *testing.T, key *dsa.PrivateKey, cert *x509.Certificate, content []byte, hash crypto.Hash) []byte {
+	t.Helper()
+
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+	if n := (key.Q.BitLen() + 7) / 8; len(digest) > n {
+		digest = digest[:n]
+	}
+	r, s, err := dsa.Sign(rand.Reader, key, digest)
+	if err != nil {
+		t.Fatalf("DSA signing failed: %v", err)
+	}
+	signature, _ := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+
+	signedData, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestOIDs[hash]}},
+		ContentInfo:      pkcs7ContentInfo{ContentType: oidData},
+		SignerInfos: []pkcs7SignerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     pkcs7IssuerAndSerial{Issuer: asn1.RawValue{FullBytes: cert.RawIssuer}, SerialNumber: cert.SerialNumber},
+			DigestAlgorithm:           pkix.AlgorithmIdentifier{Algorithm: digestOIDs[hash]},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidDSA},
+			EncryptedDigest:           signature,
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Encoding SignedData failed: %v", err)
+	}
+
+	envelope, err := asn1.Marshal(pkcs7ContentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedData},
+	})
+	if err != nil {
+		t.Fatalf("Encoding ContentInfo failed: %v", err)
+	}
+	return envelope
+}
+
+// signedQuery appends a SHA-1 secKey to a query string. SAP signs the values of all
+// parameters in the order they appear in the URL.
+func signedQuery(t *testing.T, key *dsa.PrivateKey, cert *x509.Certificate, query string) string {
+	t.Helper()
+	return signedQueryWith(t, key, cert, query, crypto.SHA1)
+}
+
+// signedQueryWith appends a secKey made with the given digest to a query string
+func signedQueryWith(t *testing.T, key *dsa.PrivateKey, cert *x509.Certificate, query string, hash crypto.Hash) string {
+	t.Helper()
+
+	var message []byte
+	for _, param := range strings.Split(query, "&") {
+		_, value, _ := strings.Cut(param, "=")
+		value, err := url.QueryUnescape(value)
+		if err != nil {
+			t.Fatalf("Invalid query parameter %q: %v", param, err)
+		}
+		message = append(message, value...)
+	}
+	secKey := base64.StdEncoding.EncodeToString(signPKCS7(t, key, cert, message, hash))
+	return query + "&secKey=" + url.QueryEscape(secKey)
+}
+
+// registerCertificate registers and activates a certificate for an authId
+func registerCertificate(t *testing.T, authID, certFile string) {
+	t.Helper()
+
+	cert, err := os.Open(certFile)
+	if err != nil {
+		t.Fatalf("Failed to open certificate: %v", err)
+	}
+	defer cert.Close()
+
+	putCert, _ := http.NewRequest("PUT", baseURL+"?putCert&contRep="+testBucket+"&authId="+authID, cert)
+	activateCert := newAdminRequest("PUT", baseURL+"?activateCert&contRep="+testBucket+"&authId="+authID, nil)
+	for _, req := range []*http.Request{putCert, activateCert} {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Certificate request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s returned status %d", req.URL.RawQuery, resp.StatusCode)
+		}
+	}
+}
+
+// TestValidSecKey verifies that a URL signed with an active certificate is accepted and that
+// changing a signed parameter invalidates the signature
+func TestValidSecKey(t *testing.T) {
+	authID := "TESTSIGNER"
+	docID := "TEST-SIGNED"
+	uploadComponent(t, docID, "data", "SIGNED CONTENT")
+	defer deleteDocument(t, docID)
+
+	registerCertificate(t, authID, "test_signer_cert.pem")
+	key, cert := loadSigner(t)
+
+	// ---- Valid signature ----
+	query := signedQuery(t, key, cert, "get=&contRep="+testBucket+"&docId="+docID+"&accessMode=r&authId="+authID+"&expiration=20991231235959")
+	resp, err := client.Get(baseURL + "?" + query)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	content, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(content) != "SIGNED CONTENT" {
+		t.Fatalf("Expected the signed document, got status %d body %q", resp.StatusCode, content)
+	}
+
+	// ---- Tampered parameter ----
+	tampered := strings.Replace(query, "docId="+docID, "docId="+docID+"X", 1)
+	resp, err = client.Get(baseURL + "?" + tampered)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 for a tampered URL, got %d", resp.StatusCode)
+	}
+
+	// ---- Tampered expiration ----
+	tampered = strings.Replace(query, "expiration=20991231235959", "expiration=20991231235958", 1)
+	resp, err = client.Get(baseURL + "?" + tampered)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 for a tampered expiration, got %d", resp.StatusCode)
+	}
+
+	// ---- The owner replaces its certificate with a signed putCert ----
+	data, err := os.ReadFile("test_signer_cert.pem")
+	if err != nil {
+		t.Fatalf("Failed to read signer certificate: %v", err)
+	}
+	query = signedQuery(t, key, cert, "putCert=&contRep="+testBucket+"&authId="+authID+"&expiration=20991231235959")
+	req, _ := http.NewRequest("PUT", baseURL+"?"+query, bytes.NewReader(data))
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("putCert request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for a signed certificate replacement, got %d", resp.StatusCode)
+	}
+
+	// ---- The replaced certificate stays active ----
+	query = signedQuery(t, key, cert, "get=&contRep="+testBucket+"&docId="+docID+"&accessMode=r&authId="+authID+"&expiration=20991231235959")
+	resp, err = client.Get(baseURL + "?" + query)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the replaced certificate to stay active, got status %d", resp.StatusCode)
+	}
+}
+
+// TestSecKeyDigestByPVersion verifies that SHA-256 signatures are only accepted from pVersion 0047
+func TestSecKeyDigestByPVersion(t *testing.T) {
+	authID := "TESTSIGNER-PVERSION"
+	docID := "TEST-SIGNED-PVERSION"
+	uploadComponent(t, docID, "data", "SIGNED CONTENT")
+	defer deleteDocument(t, docID)
+
+	registerCertificate(t, authID, "test_signer_cert.pem")
+	key, cert := loadSigner(t)
+
+	for pVersion, expected := range map[string]int{"0045": http.StatusUnauthorized, "0046": http.StatusUnauthorized, "0047": http.StatusOK} {
+		query := signedQueryWith(t, key, cert, "get=&contRep="+testBucket+"&docId="+docID+"&pVersion="+pVersion+"&accessMode=r&authId="+authID+"&expiration=20991231235959", crypto.SHA256)
+		resp, err := client.Get(baseURL + "?" + query)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != expected {
+			t.Fatalf("Expected status %d for a SHA-256 secKey with pVersion %s, got %d", expected, pVersion, resp.StatusCode)
+		}
+	}
+
+	// ---- SHA-1 is accepted by every version ----
+	query := signedQuery(t, key, cert, "get=&contRep="+testBucket+"&docId="+docID+"&pVersion=0045&accessMode=r&authId="+authID+"&expiration=20991231235959")
+	resp, err := client.Get(baseURL + "?" + query)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for a SHA-1 secKey with pVersion 0045, got %d", resp.StatusCode)
+	}
+}
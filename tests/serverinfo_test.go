@@ -0,0 +1,36 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// TestServerInfo verifies the ASCII serverInfo response for one repository
+func TestServerInfo(t *testing.T) {
+	resp, err := client.Get(baseURL + "?serverInfo&contRep=" + testBucket + "&resultAs=ascii")
+	if err != nil {
+		t.Fatalf("serverInfo request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("serverInfo returned status %d", resp.StatusCode)
+	}
+
+	body, _ := io.ReadAll(resp.Body)
+	lines := strings.Split(strings.TrimSpace(string(body)), "\r\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected server and repository lines, got %q", string(body))
+	}
+	if !strings.Contains(lines[0], `serverStatus="running"`) {
+		t.Fatalf("Unexpected server line: %q", lines[0])
+	}
+	if !strings.Contains(lines[1], `contRep="`+testBucket+`"`) {
+		t.Fatalf("Unexpected repository line: %q", lines[1])
+	}
+	if !strings.Contains(lines[1], `uploadPartSize="`) || !strings.Contains(lines[1], `uploadConcurrency="`) {
+		t.Fatalf("Uploader settings missing from repository line: %q", lines[1])
+	}
+}
@@ -0,0 +1,215 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	memURL = "https://localhost:8080/mem"
+
+	// streamedSize is the size of the documents moved in the streaming tests
+	streamedSize = 64 << 20
+
+	// maxStreamedAlloc bounds the server heap while a document of streamedSize is moved
+	maxStreamedAlloc = 48 << 20
+
+	// maxDownloadGrowth bounds how much the server heap grows while a document of
+	// streamedSize is downloaded; a buffered download grows it by the whole document
+	maxDownloadGrowth = streamedSize / 2
+
+	// downloadSampleInterval is how many bytes are downloaded between two heap samples
+	downloadSampleInterval = 4 << 20
+)
+
+// patternReader produces n bytes of a repeating pattern without holding them in memory
+type patternReader struct {
+	n int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	if r.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.n {
+		p = p[:r.n]
+	}
+	for i := range p {
+		p[i] = byte('A' + i%26)
+	}
+	r.n -= int64(len(p))
+	return len(p), nil
+}
+
+// sampleAlloc polls the server heap from /mem until stop is closed and returns the peak
+func sampleAlloc(t *testing.T, stop <-chan struct{}) <-chan uint64 {
+	t.Helper()
+
+	peak := make(chan uint64, 1)
+	go func() {
+		var max uint64
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				peak <- max
+				return
+			case <-ticker.C:
+			}
+			resp, err := client.Get(memURL)
+			if err != nil {
+				continue
+			}
+			var mem struct {
+				Alloc uint64 `json:"alloc"`
+			}
+			json.NewDecoder(resp.Body).Decode(&mem)
+			resp.Body.Close()
+			if mem.Alloc > max {
+				max = mem.Alloc
+			}
+		}
+	}()
+	return peak
+}
+
+// currentAlloc returns the heap the server has allocated now
+func currentAlloc(t *testing.T) uint64 {
+	t.Helper()
+
+	resp, err := client.Get(memURL)
+	if err != nil {
+		t.Fatalf("Mem request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var mem struct {
+		Alloc uint64 `json:"alloc"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&mem); err != nil {
+		t.Fatalf("Decoding mem failed: %v", err)
+	}
+	return mem.Alloc
+}
+
+// sampledReader reads a download and samples the server heap every downloadSampleInterval
+// bytes, so every sample is taken while the response is being sent
+type sampledReader struct {
+	t       *testing.T
+	r       io.Reader
+	read    int64
+	sampled int64
+	peak    uint64
+}
+
+func (s *sampledReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	s.read += int64(n)
+	if s.read-s.sampled >= downloadSampleInterval {
+		s.sampled = s.read
+		s.peak = max(s.peak, currentAlloc(s.t))
+	}
+	return n, err
+}
+
+// streamedUpload returns a create request for a document of streamedSize bytes. The body is
+// produced while it is sent, so the request is chunked and never held in memory.
+func streamedUpload(docID string) *http.Request {
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		fileWriter, err := writer.CreateFormFile("file", "large.bin")
+		if err == nil {
+			_, err = io.Copy(fileWriter, &patternReader{n: streamedSize})
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID, pr)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+// TestStreamedUpload verifies that a multipart upload is piped to S3 without buffering the body
+func TestStreamedUpload(t *testing.T) {
+	docID := "TEST-STREAMED-UPLOAD"
+	defer deleteDocument(t, docID)
+
+	stop := make(chan struct{})
+	peak := sampleAlloc(t, stop)
+
+	resp, err := client.Do(streamedUpload(docID))
+	close(stop)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	if max := <-peak; max > maxStreamedAlloc {
+		t.Fatalf("Server heap reached %d bytes during a %d byte upload", max, streamedSize)
+	}
+
+	head, err := s3Client.HeadObject(context.TODO(), &s3.HeadObjectInput{
+		Bucket: aws.String(testBucket),
+		Key:    aws.String(docID + "/data"),
+	})
+	if err != nil {
+		t.Fatalf("HeadObject failed: %v", err)
+	}
+	if size := aws.ToInt64(head.ContentLength); size != streamedSize {
+		t.Fatalf("Expected %d bytes stored, got %d", streamedSize, size)
+	}
+}
+
+// TestStreamedDownload verifies that get streams a component from S3 instead of buffering it
+func TestStreamedDownload(t *testing.T) {
+	docID := "TEST-STREAMED-DOWNLOAD"
+	defer deleteDocument(t, docID)
+
+	resp, err := client.Do(streamedUpload(docID))
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	// Only the heap growth during the download counts, not what earlier requests left behind
+	before := currentAlloc(t)
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	body := &sampledReader{t: t, r: resp.Body}
+	n, err := io.Copy(io.Discard, body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Reading downloaded data failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.ContentLength != streamedSize || n != streamedSize {
+		t.Fatalf("Expected %d bytes with status 200, got %d (Content-Length %d, status %d)",
+			streamedSize, n, resp.ContentLength, resp.StatusCode)
+	}
+
+	if body.peak > before && body.peak-before > maxDownloadGrowth {
+		t.Fatalf("Server heap grew by %d bytes during a %d byte download, expected at most %d",
+			body.peak-before, streamedSize, maxDownloadGrowth)
+	}
+}
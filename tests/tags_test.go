@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// createWithHeaders creates the data component of a document sending extra request headers
+func createWithHeaders(t *testing.T, docID, filename string, headers map[string]string) *http.Response {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	fileWriter, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatalf("Failed to create multipart form: %v", err)
+	}
+	fileWriter.Write([]byte("TAGGED CONTENT"))
+	writer.Close()
+
+	req, _ := http.NewRequest("POST", baseURL+"?contRep="+testBucket+"&docId="+docID, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Upload request failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+// getTags returns the tags of the data component of a document
+func getTags(t *testing.T, docID string) map[string]string {
+	t.Helper()
+
+	resp, err := client.Get(baseURL + "?getTags&contRep=" + testBucket + "&docId=" + docID)
+	if err != nil {
+		t.Fatalf("getTags request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("getTags returned status %d", resp.StatusCode)
+	}
+	var tags map[string]map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		t.Fatalf("Decoding tags failed: %v", err)
+	}
+	return tags["data"]
+}
+
+// TestTags verifies tag escaping, X-tag-* headers, the tag limits and getTags/setTags
+func TestTags(t *testing.T) {
+	docID := "TEST-TAGS"
+	defer deleteDocument(t, docID)
+
+	// ---- Create with an extra tag whose value needs escaping ----
+	resp := createWithHeaders(t, docID, "a&b=c d.txt", map[string]string{"X-tag-retention": "10 years & more"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Upload returned status %d", resp.StatusCode)
+	}
+
+	tags := getTags(t, docID)
+	if tags["retention"] != "10 years & more" || tags["docId"] != docID {
+		t.Fatalf("Unexpected tags: %v", tags)
+	}
+
+	// ---- setTags replaces the extra tags ----
+	req, _ := http.NewRequest("PUT", baseURL+"?setTags&contRep="+testBucket+"&docId="+docID, nil)
+	req.Header.Set("X-tag-department", "finance")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("setTags request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("setTags returned status %d", resp.StatusCode)
+	}
+
+	tags = getTags(t, docID)
+	if tags["department"] != "finance" || tags["retention"] != "" || tags["compId"] != "data" {
+		t.Fatalf("Unexpected tags after setTags: %v", tags)
+	}
+
+	// ---- Tag not allowed by the repository ----
+	resp = createWithHeaders(t, "TEST-TAGS-DENIED", "x.txt", map[string]string{"X-tag-unknown": "x"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a disallowed tag, got %d", resp.StatusCode)
+	}
+
+	// ---- Value longer than the S3 limit ----
+	resp = createWithHeaders(t, "TEST-TAGS-LONG", "x.txt", map[string]string{"X-tag-retention": strings.Repeat("x", 257)})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a long tag value, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"testing"
+)
+
+// TestUpdate verifies that update replaces existing components and adds new ones
+func TestUpdate(t *testing.T) {
+	docID := "TEST-UPDATE"
+	uploadComponent(t, docID, "data", "OLD DATA")
+	defer deleteDocument(t, docID)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for compID, content := range map[string]string{"data": "NEW DATA", "note": "NEW NOTE"} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="`+compID+`"; filename="`+compID+`.txt"`)
+		header.Set("X-compId", compID)
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		part.Write([]byte(content))
+	}
+	writer.Close()
+
+	req, err := http.NewRequest("PUT", baseURL+"?update&contRep="+testBucket+"&docId="+docID, &body)
+	if err != nil {
+		t.Fatalf("Update request creation failed: %v", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Update request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Update returned status %d", resp.StatusCode)
+	}
+
+	for compID, expected := range map[string]string{"data": "NEW DATA", "note": "NEW NOTE"} {
+		resp, err := client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=" + compID)
+		if err != nil {
+			t.Fatalf("Download request failed: %v", err)
+		}
+		downloaded, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if string(downloaded) != expected {
+			t.Fatalf("Component %s content mismatch: got %q", compID, string(downloaded))
+		}
+	}
+}
+
+// TestUpdateNotFound verifies that updating a missing document returns 404
+func TestUpdateNotFound(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("data", "data.txt")
+	part.Write([]byte("DATA"))
+	writer.Close()
+
+	req, _ := http.NewRequest("PUT", baseURL+"?update&contRep="+testBucket+"&docId=NO_SUCH_FILE_123", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Update request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("Expected 404 Not Found, got %d", resp.StatusCode)
+	}
+}
+
+// TestUpdateFailureKeepsDocument verifies that an update failing after some parts have arrived
+// leaves every component unchanged
+func TestUpdateFailureKeepsDocument(t *testing.T) {
+	docID := "TEST-UPDATE-FAIL"
+	uploadComponent(t, docID, "data", "OLD DATA")
+	defer deleteDocument(t, docID)
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for _, compID := range []string{"data", "bad/comp"} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", `form-data; name="file"; filename="file.txt"`)
+		header.Set("X-compId", compID)
+
+		part, err := writer.CreatePart(header)
+		if err != nil {
+			t.Fatalf("Failed to create part: %v", err)
+		}
+		part.Write([]byte("NEW DATA"))
+	}
+	writer.Close()
+
+	req, _ := http.NewRequest("PUT", baseURL+"?update&contRep="+testBucket+"&docId="+docID, &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Update request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected 400 Bad Request for an invalid compId, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "?get&contRep=" + testBucket + "&docId=" + docID + "&compId=data")
+	if err != nil {
+		t.Fatalf("Download request failed: %v", err)
+	}
+	downloaded, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(downloaded) != "OLD DATA" {
+		t.Fatalf("Expected the data component to be unchanged, got %q", string(downloaded))
+	}
+}
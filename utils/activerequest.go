@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// requestEnd ends a request, once its handler has returned or, for a streamed response,
+// once the stream is closed
+type requestEnd struct {
+	end  func()
+	held bool
+}
+
+// TrackRequest registers end as the function that ends a request: it stops counting the
+// request as active and releases its context. The returned function must be called when
+// the handler has returned; it ends the request unless a streamed response holds it.
+func TrackRequest(c *fiber.Ctx, end func()) func() {
+	r := &requestEnd{end: end}
+	c.Locals("requestEnd", r)
+	return func() {
+		if !r.held {
+			r.end()
+		}
+	}
+}
+
+// HoldRequest keeps a request active after its handler has returned, for a response that
+// is sent afterwards, so graceful shutdown waits for it and can cancel it. The returned
+// function ends the request and must be called once the response has been sent or abandoned.
+func HoldRequest(c *fiber.Ctx) func() {
+	r, ok := c.Locals("requestEnd").(*requestEnd)
+	if !ok {
+		return func() {}
+	}
+	r.held = true
+	var once sync.Once
+	return func() { once.Do(r.end) }
+}
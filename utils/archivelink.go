@@ -0,0 +1,266 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// DefaultPVersion is the ArchiveLink protocol version reported when the client sends none
+const DefaultPVersion = "0047"
+
+// SupportedPVersions lists the ArchiveLink protocol versions understood by the server
+var SupportedPVersions = []string{"0045", "0046", "0047"}
+
+// Document and component states reported in ArchiveLink responses
+const (
+	DocStatusOnline  = "online"
+	CompStatusOnline = "online"
+)
+
+// ErrDocumentNotFound is returned when a document has no components
+var ErrDocumentNotFound = errors.New("document not found")
+
+// ComponentInfo describes one component in ArchiveLink terms
+type ComponentInfo struct {
+	CompID        string    `json:"compId"`
+	Filename      string    `json:"filename,omitempty"`
+	ContentType   string    `json:"contentType"`
+	Charset       string    `json:"charset,omitempty"`
+	Version       string    `json:"version,omitempty"`
+	ContentLength int64     `json:"size"`
+	DateC         string    `json:"dateC"`
+	TimeC         string    `json:"timeC"`
+	DateM         string    `json:"dateM"`
+	TimeM         string    `json:"timeM"`
+	Status        string    `json:"status"`
+	ETag          string    `json:"etag"`
+	LastModified  time.Time `json:"lastModified"`
+}
+
+// DocumentInfo describes a document and its components in ArchiveLink terms
+type DocumentInfo struct {
+	DocID       string          `json:"docId"`
+	ContRep     string          `json:"contRep"`
+	DateC       string          `json:"dateC"`
+	TimeC       string          `json:"timeC"`
+	DateM       string          `json:"dateM"`
+	TimeM       string          `json:"timeM"`
+	Status      string          `json:"docStatus"`
+	PVersion    string          `json:"pVersion"`
+	NumberComps int             `json:"numberComps"`
+	Components  []ComponentInfo `json:"components"`
+}
+
+// LoadComponentInfo reads the attributes of one component from S3
+func LoadComponentInfo(ctx context.Context, repo *Repository, docID, key string) (ComponentInfo, error) {
+	head, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ComponentInfo{}, err
+	}
+
+	meta := componentMeta(ctx, repo, key, head.Metadata)
+
+	modified := aws.ToTime(head.LastModified)
+	info := ComponentInfo{
+		CompID:        repo.CompIDFromKey(docID, key),
+		Filename:      meta.Filename,
+		ContentType:   aws.ToString(head.ContentType),
+		ContentLength: aws.ToInt64(head.ContentLength),
+		DateC:         valueOr(meta.DateC, modified.Format(DateLayout)),
+		TimeC:         valueOr(meta.TimeC, modified.Format(TimeLayout)),
+		DateM:         valueOr(meta.DateM, modified.Format(DateLayout)),
+		TimeM:         valueOr(meta.TimeM, modified.Format(TimeLayout)),
+		Status:        CompStatusOnline,
+		ETag:          aws.ToString(head.ETag),
+		LastModified:  modified,
+	}
+	if info.ContentType == "" {
+		info.ContentType = DefaultContentType
+	}
+	info.Charset, info.Version = ContentTypeParams(info.ContentType)
+	return info, nil
+}
+
+// LoadDocumentInfo reads the attributes of a document and its components from S3.
+// When compID is set only that component is described.
+func LoadDocumentInfo(ctx context.Context, repo *Repository, docID, compID string) (*DocumentInfo, error) {
+	var keys []string
+	if compID != "" {
+		key, _, err := ResolveComponent(ctx, repo, docID, compID)
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		keys = []string{key}
+	} else {
+		objects, err := ListComponents(ctx, repo, docID)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range objects {
+			keys = append(keys, aws.ToString(obj.Key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrDocumentNotFound
+	}
+
+	doc := &DocumentInfo{
+		DocID:    docID,
+		ContRep:  repo.Name,
+		Status:   DocStatusOnline,
+		PVersion: DefaultPVersion,
+	}
+	for _, key := range keys {
+		comp, err := LoadComponentInfo(ctx, repo, docID, key)
+		if err != nil {
+			if IsNotFound(err) {
+				return nil, ErrDocumentNotFound
+			}
+			return nil, err
+		}
+		doc.Components = append(doc.Components, comp)
+
+		// The document was created with its first component and modified with its last one
+		if doc.DateC == "" || comp.DateC+comp.TimeC < doc.DateC+doc.TimeC {
+			doc.DateC, doc.TimeC = comp.DateC, comp.TimeC
+		}
+		if comp.DateM+comp.TimeM > doc.DateM+doc.TimeM {
+			doc.DateM, doc.TimeM = comp.DateM, comp.TimeM
+		}
+	}
+	doc.NumberComps = len(doc.Components)
+	return doc, nil
+}
+
+// Headers returns the document attributes as ArchiveLink response headers of its pVersion
+func (d *DocumentInfo) Headers() map[string]string {
+	headers := map[string]string{
+		"X-dateC":       d.DateC,
+		"X-timeC":       d.TimeC,
+		"X-dateM":       d.DateM,
+		"X-timeM":       d.TimeM,
+		"X-contRep":     d.ContRep,
+		"X-numberComps": strconv.Itoa(d.NumberComps),
+		"X-docId":       d.DocID,
+		"X-pVersion":    d.PVersion,
+	}
+	if PVersionOffers(d.PVersion, "docStatus") {
+		headers["X-docStatus"] = d.Status
+	}
+	return headers
+}
+
+// MultipartBody encodes the components as multipart/form-data parts without content,
+// with the part headers of the document's pVersion
+func (d *DocumentInfo) MultipartBody(boundary string) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+
+	for _, comp := range d.Components {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", comp.ContentType)
+		header.Set("X-compId", comp.CompID)
+		if comp.Charset != "" {
+			header.Set("X-charset", comp.Charset)
+		}
+		if comp.Version != "" {
+			header.Set("X-version", comp.Version)
+		}
+		header.Set("X-Content-Length", strconv.FormatInt(comp.ContentLength, 10))
+		header.Set("X-compDateC", comp.DateC)
+		header.Set("X-compTimeC", comp.TimeC)
+		header.Set("X-compDateM", comp.DateM)
+		header.Set("X-compTimeM", comp.TimeM)
+		if PVersionOffers(d.PVersion, "compStatus") {
+			header.Set("X-compStatus", comp.Status)
+		}
+		header.Set("X-pVersion", d.PVersion)
+		if _, err := mw.CreatePart(header); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ASCIIBody encodes the document (first line) and its components (one line each)
+// in the ArchiveLink resultAs=ascii format of the document's pVersion
+func (d *DocumentInfo) ASCIIBody() string {
+	var sb strings.Builder
+	writeASCIILine(&sb, d.offered(
+		"docId", d.DocID,
+		"contRep", d.ContRep,
+		"numberComps", strconv.Itoa(d.NumberComps),
+		"dateC", d.DateC,
+		"timeC", d.TimeC,
+		"dateM", d.DateM,
+		"timeM", d.TimeM,
+		"docStatus", d.Status,
+		"pVersion", d.PVersion,
+	)...)
+	for _, comp := range d.Components {
+		writeASCIILine(&sb, d.offered(
+			"compId", comp.CompID,
+			"contentType", comp.ContentType,
+			"charset", comp.Charset,
+			"version", comp.Version,
+			"contentLength", strconv.FormatInt(comp.ContentLength, 10),
+			"compDateC", comp.DateC,
+			"compTimeC", comp.TimeC,
+			"compDateM", comp.DateM,
+			"compTimeM", comp.TimeM,
+			"compStatus", comp.Status,
+			"pVersion", d.PVersion,
+		)...)
+	}
+	return sb.String()
+}
+
+// offered drops the key/value pairs the document's pVersion does not define
+func (d *DocumentInfo) offered(pairs ...string) []string {
+	kept := make([]string, 0, len(pairs))
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if PVersionOffers(d.PVersion, pairs[i]) {
+			kept = append(kept, pairs[i], pairs[i+1])
+		}
+	}
+	return kept
+}
+
+// writeASCIILine writes key/value pairs as one `key="value";...` line terminated by CRLF
+func writeASCIILine(sb *strings.Builder, pairs ...string) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			sb.WriteByte(';')
+		}
+		fmt.Fprintf(sb, "%s=%q", pairs[i], pairs[i+1])
+	}
+	sb.WriteString("\r\n")
+}
+
+// valueOr returns v, or def when v is empty
+func valueOr(v, def string) string {
+	if v != "" {
+		return v
+	}
+	return def
+}
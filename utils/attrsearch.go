@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// DefaultDescrCompID is the component holding the attribute descriptor of a document
+const DefaultDescrCompID = "descr"
+
+// maxDescriptorLine is the longest descriptor line accepted by attrSearch
+const maxDescriptorLine = 1024 * 1024
+
+// AttrHit is an entry of the data component matched by an attribute search
+type AttrHit struct {
+	Offset int64
+	Length int64
+}
+
+// attrCondition compares one attribute with a value pattern (* and ? wildcards)
+type attrCondition struct {
+	name    string
+	pattern string
+}
+
+// AttrExpression is an attribute search expression: alternatives separated by |,
+// each a list of name=pattern conditions separated by & that must all hold
+type AttrExpression [][]attrCondition
+
+// ParseAttrExpression parses an expression such as `customer=4711&type=INV*|type=CRN`
+func ParseAttrExpression(expr string) (AttrExpression, error) {
+	var parsed AttrExpression
+	for _, alternative := range strings.Split(expr, "|") {
+		var conds []attrCondition
+		for _, cond := range strings.Split(alternative, "&") {
+			name, pattern, ok := strings.Cut(strings.TrimSpace(cond), "=")
+			if !ok || name == "" {
+				return nil, fmt.Errorf("invalid attribute condition %q", cond)
+			}
+			conds = append(conds, attrCondition{name: name, pattern: pattern})
+		}
+		parsed = append(parsed, conds)
+	}
+	return parsed, nil
+}
+
+// Match reports whether the attributes of a descriptor entry satisfy the expression
+func (e AttrExpression) Match(attrs map[string]string, caseSensitive bool) bool {
+	for _, conds := range e {
+		matched := true
+		for _, cond := range conds {
+			value, ok := attrs[cond.name]
+			if !ok || !wildcardMatch(cond.pattern, value, caseSensitive) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// AttrSearchStream reads a descriptor component line by line and returns the entries
+// whose attributes match the expression. Each descriptor line has the form
+// `offset;length;name=value;name=value...`; empty lines and lines starting with # are
+// ignored. Only entries starting within [from, to] are considered (to < 0 means no
+// upper bound). maxHits <= 0 means MaxSearchHits.
+func AttrSearchStream(r io.Reader, expr AttrExpression, caseSensitive bool, from, to int64, maxHits int) ([]AttrHit, error) {
+	if maxHits <= 0 || maxHits > MaxSearchHits {
+		maxHits = MaxSearchHits
+	}
+
+	var hits []AttrHit
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxDescriptorLine)
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hit, attrs, err := parseDescriptorLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor line %d: %w", lineNo, err)
+		}
+		if hit.Offset < from || (to >= 0 && hit.Offset > to) {
+			continue
+		}
+		if expr.Match(attrs, caseSensitive) {
+			hits = append(hits, hit)
+			if len(hits) >= maxHits {
+				break
+			}
+		}
+	}
+	return hits, scanner.Err()
+}
+
+// parseDescriptorLine splits a descriptor line into the entry position and its attributes
+func parseDescriptorLine(line string) (AttrHit, map[string]string, error) {
+	fields := strings.Split(line, ";")
+	if len(fields) < 2 {
+		return AttrHit{}, nil, fmt.Errorf("expected offset;length, got %q", line)
+	}
+
+	offset, err := strconv.ParseInt(strings.TrimSpace(fields[0]), 10, 64)
+	if err != nil {
+		return AttrHit{}, nil, fmt.Errorf("invalid offset %q", fields[0])
+	}
+	length, err := strconv.ParseInt(strings.TrimSpace(fields[1]), 10, 64)
+	if err != nil {
+		return AttrHit{}, nil, fmt.Errorf("invalid length %q", fields[1])
+	}
+
+	attrs := make(map[string]string, len(fields)-2)
+	for _, field := range fields[2:] {
+		if name, value, ok := strings.Cut(field, "="); ok {
+			attrs[strings.TrimSpace(name)] = value
+		}
+	}
+	return AttrHit{Offset: offset, Length: length}, attrs, nil
+}
+
+// FormatAttrSearchResult encodes hits in the ArchiveLink attrSearch result format:
+// the number of hits followed by offset and length of each hit, each terminated by a semicolon
+func FormatAttrSearchResult(hits []AttrHit) string {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(hits)))
+	sb.WriteByte(';')
+	for _, hit := range hits {
+		sb.WriteString(strconv.FormatInt(hit.Offset, 10))
+		sb.WriteByte(';')
+		sb.WriteString(strconv.FormatInt(hit.Length, 10))
+		sb.WriteByte(';')
+	}
+	return sb.String()
+}
+
+// wildcardMatch matches a value against a pattern where * matches any run of
+// characters and ? matches a single character
+func wildcardMatch(pattern, value string, caseSensitive bool) bool {
+	if !caseSensitive {
+		pattern, value = strings.ToLower(pattern), strings.ToLower(value)
+	}
+	p, v := []rune(pattern), []rune(value)
+
+	// Iterative matching with backtracking to the last *
+	pi, vi, star, mark := 0, 0, -1, 0
+	for vi < len(v) {
+		switch {
+		case pi < len(p) && (p[pi] == '?' || p[pi] == v[vi]):
+			pi++
+			vi++
+		case pi < len(p) && p[pi] == '*':
+			star, mark = pi, vi
+			pi++
+		case star >= 0:
+			pi = star + 1
+			mark++
+			vi = mark
+		default:
+			return false
+		}
+	}
+	for pi < len(p) && p[pi] == '*' {
+		pi++
+	}
+	return pi == len(p)
+}
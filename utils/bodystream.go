@@ -0,0 +1,195 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrBodyTooLarge is returned while reading a request body larger than the body limit
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// EnforceBodyLimit returns a middleware that rejects requests declaring a body larger than
+// the configured body limit. With request body streaming the server no longer does this
+// itself; bodies without a Content-Length are checked while they are read, which is why
+// handlers read bodies only through RequestBodyStream and never with c.Body or c.MultipartForm.
+func EnforceBodyLimit() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		limit := c.App().Config().BodyLimit
+		if limit > 0 && c.Request().Header.ContentLength() > limit {
+			logRequest(c, time.Now(), "ERROR=request body too large")
+			return c.Status(http.StatusRequestEntityTooLarge).SendString(ErrBodyTooLarge.Error())
+		}
+		return c.Next()
+	}
+}
+
+// RequestBodyStream returns the body of a request as a stream. The server reads only the
+// first few KiB before the handler runs; the rest is read from the connection as the
+// returned reader is consumed. Reading fails with ErrBodyTooLarge after the body limit.
+func RequestBodyStream(c *fiber.Ctx) io.Reader {
+	body := c.Request().BodyStream()
+	if body == nil {
+		body = bytes.NewReader(c.Body())
+	}
+	limit := c.App().Config().BodyLimit
+	if limit <= 0 {
+		return body
+	}
+	return &limitedBody{r: body, remaining: int64(limit)}
+}
+
+// limitedBody reads from r until more than remaining bytes have been read
+type limitedBody struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, ErrBodyTooLarge
+	}
+	return n, err
+}
+
+// multipartFormMemory is how much of a form ReadMultipartForm keeps in memory; larger files
+// are written to temporary files
+const multipartFormMemory = 8 << 20
+
+// ReadMultipartForm parses a whole multipart/form-data body for commands that need all its
+// files at once. Unlike c.MultipartForm, which no longer checks the body limit once request
+// bodies are streamed, it reads the body through RequestBodyStream, so it fails with
+// ErrBodyTooLarge. The caller must call RemoveAll on the form.
+func ReadMultipartForm(c *fiber.Ctx) (*multipart.Form, error) {
+	mr, err := MultipartReader(c)
+	if err != nil {
+		return nil, err
+	}
+	form, err := mr.ReadForm(multipartFormMemory)
+	if err != nil {
+		return nil, fmt.Errorf("multipart reader: %w", err)
+	}
+	return form, nil
+}
+
+// readAtMost reads r into memory, failing with ErrBodyTooLarge when it holds more than max bytes
+func readAtMost(r io.Reader, max int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, ErrBodyTooLarge
+	}
+	return data, nil
+}
+
+// MultipartReader returns a reader over the parts of a multipart/form-data body as they
+// arrive on the connection, reading the body through RequestBodyStream
+func MultipartReader(c *fiber.Ctx) (*multipart.Reader, error) {
+	boundary := string(c.Request().Header.MultipartFormBoundary())
+	if boundary == "" {
+		return nil, fmt.Errorf("multipart reader: request is not multipart/form-data")
+	}
+	return multipart.NewReader(RequestBodyStream(c), boundary), nil
+}
+
+// ExtractFileStream returns the first file part of a multipart request as it arrives on
+// the connection. The body is parsed incrementally, so nothing but the part headers is
+// buffered; the returned part is valid until the next part is read. Form fields before
+// the file part are skipped.
+func ExtractFileStream(c *fiber.Ctx) (io.Reader, *multipart.Part, error) {
+	mr, err := MultipartReader(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("no file part found")
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("multipart reader: %w", err)
+		}
+		if part.FileName() != "" {
+			return part, part, nil
+		}
+	}
+}
+
+// memorySampleInterval is how many bytes of a streamed response are sent between two
+// samples of the heap for the maxAlloc statistic
+const memorySampleInterval = 8 << 20
+
+// SendStream sends body as the response body with a known length. The body is read while
+// the response is written, after the handler has returned, and closed once it has been
+// sent or the client has gone away. onClose, if set, is called with the number of bytes
+// sent; it must not use the fiber.Ctx, which is released by then. The request stays active
+// until the body is closed.
+func SendStream(c *fiber.Ctx, body io.ReadCloser, size int64, onClose func(sent int64)) {
+	c.Response().SetBodyStream(&responseStream{body: body, onClose: onClose, done: HoldRequest(c)}, int(size))
+}
+
+// responseStream is the body of a streamed response
+type responseStream struct {
+	body    io.ReadCloser
+	sent    int64
+	sampled int64
+	onClose func(sent int64)
+	done    func()
+}
+
+func (s *responseStream) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	s.sent += int64(n)
+	if s.sent-s.sampled >= memorySampleInterval {
+		s.sampled = s.sent
+		updateMaxMemory()
+	}
+	return n, err
+}
+
+func (s *responseStream) Close() error {
+	err := s.body.Close()
+	updateMaxMemory()
+	if s.onClose != nil {
+		s.onClose(s.sent)
+	}
+	s.done()
+	return err
+}
+
+// IsMultipartForm reports whether the body of a request is multipart/form-data
+func IsMultipartForm(c *fiber.Ctx) bool {
+	return len(c.Request().Header.MultipartFormBoundary()) > 0
+}
+
+// ExtractBodyStream returns the body of a non-multipart request as the content of a single
+// component, together with its declared Content-Type and its filename. The filename is
+// taken from the Content-Disposition header, or else from the filename query parameter.
+func ExtractBodyStream(c *fiber.Ctx) (io.Reader, string, string) {
+	return RequestBodyStream(c), c.Get(fiber.HeaderContentType), RequestFilename(c)
+}
+
+// RequestFilename returns the filename of content sent without a multipart form: the
+// filename of the Content-Disposition header, or else the filename query parameter
+func RequestFilename(c *fiber.Ctx) string {
+	filename := c.Query("filename")
+	if _, params, err := mime.ParseMediaType(c.Get(fiber.HeaderContentDisposition)); err == nil && params["filename"] != "" {
+		filename = params["filename"]
+	}
+	if filename != "" {
+		filename = filepath.Base(filename)
+	}
+	return filename
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// CertPrefix is the reserved key prefix under which client certificates are stored in a repository
+const CertPrefix = "_certs/"
+
+// certCacheTTL is how long a loaded certificate is reused before it is read from S3 again
+const certCacheTTL = time.Minute
+
+// ErrCertNotFound is returned when no certificate is registered for an authId
+var ErrCertNotFound = errors.New("certificate not found")
+
+// RegisteredCert is a client certificate registered for a content repository
+type RegisteredCert struct {
+	AuthID string
+	Cert   *x509.Certificate
+	Active bool
+	loaded time.Time
+}
+
+// certCache holds recently loaded certificates keyed by contRep and authId
+var certCache sync.Map
+
+// CertKey returns the repository-relative key of the certificate registered for an authId
+func CertKey(authID string) string {
+	return CertPrefix + authID
+}
+
+// ParseCertificate parses a PEM or DER encoded X.509 certificate
+func ParseCertificate(data []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
+
+// LoadCertificate returns the certificate registered for an authId in a repository
+func LoadCertificate(ctx context.Context, repo *Repository, authID string) (*RegisteredCert, error) {
+	cacheKey := repo.Name + "/" + authID
+	if v, ok := certCache.Load(cacheKey); ok {
+		rc := v.(*RegisteredCert)
+		if time.Since(rc.loaded) < certCacheTTL {
+			return rc, nil
+		}
+	}
+
+	out, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(repo.Key(CertKey(authID))),
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			return nil, ErrCertNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := ParseCertificate(data)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &RegisteredCert{
+		AuthID: authID,
+		Cert:   cert,
+		Active: out.Metadata["active"] == "true",
+		loaded: time.Now(),
+	}
+	certCache.Store(cacheKey, rc)
+	return rc, nil
+}
+
+// StoreCertificate registers a certificate for an authId, replacing any previous one
+func StoreCertificate(ctx context.Context, repo *Repository, authID string, data []byte, active bool) error {
+	_, err := repo.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(repo.Bucket),
+		Key:         aws.String(repo.Key(CertKey(authID))),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/pkix-cert"),
+		Metadata:    map[string]string{"active": strconv.FormatBool(active)},
+	})
+	certCache.Delete(repo.Name + "/" + authID)
+	return err
+}
+
+// SetCertificateActive activates or deactivates the certificate registered for an authId
+func SetCertificateActive(ctx context.Context, repo *Repository, authID string, active bool) error {
+	key := repo.Key(CertKey(authID))
+	_, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if IsNotFound(err) {
+			return ErrCertNotFound
+		}
+		return err
+	}
+
+	_, err = repo.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(repo.Bucket),
+		Key:               aws.String(key),
+		CopySource:        aws.String(url.PathEscape(repo.Bucket + "/" + key)),
+		ContentType:       aws.String("application/pkix-cert"),
+		Metadata:          map[string]string{"active": strconv.FormatBool(active)},
+		MetadataDirective: types.MetadataDirectiveReplace,
+	})
+	certCache.Delete(repo.Name + "/" + authID)
+	return err
+}
+
+// ListCertificates returns all certificates registered in a repository
+func ListCertificates(ctx context.Context, repo *Repository) ([]*RegisteredCert, error) {
+	var certs []*RegisteredCert
+
+	paginator := s3.NewListObjectsV2Paginator(repo.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(repo.Bucket),
+		Prefix: aws.String(repo.Key(CertPrefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			authID := strings.TrimPrefix(repo.RelKey(aws.ToString(obj.Key)), CertPrefix)
+			rc, err := LoadCertificate(ctx, repo, authID)
+			if err != nil {
+				return nil, err
+			}
+			certs = append(certs, rc)
+		}
+	}
+	return certs, nil
+}
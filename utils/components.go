@@ -0,0 +1,216 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DefaultCompID is the component used when a request does not name one
+const DefaultCompID = "data"
+
+// maxDeleteBatch is the maximum number of keys accepted by a single DeleteObjects call
+const maxDeleteBatch = 1000
+
+// Layouts of the ArchiveLink date and time attributes
+const (
+	DateLayout = "2006-01-02"
+	TimeLayout = "15:04:05"
+)
+
+// reservedPrefixes are key prefixes used by the adapter itself, never by documents
+var reservedPrefixes = []string{CertPrefix, AdminPrefix, UploadPrefix, StagingPrefix}
+
+// IsReservedDocID reports whether a docId would address a reserved key prefix
+func IsReservedDocID(docID string) bool {
+	return slices.Contains(reservedPrefixes, DocumentPrefix(docID))
+}
+
+// IsReservedKey reports whether a repository-relative key belongs to a reserved key prefix
+func IsReservedKey(key string) bool {
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ComponentKey returns the key of a component relative to its repository: <docId>/<compId>
+func ComponentKey(docID, compID string) string {
+	return DocumentPrefix(docID) + compID
+}
+
+// DocumentPrefix returns the key prefix shared by all components of a document, relative to its repository
+func DocumentPrefix(docID string) string {
+	return docID + "/"
+}
+
+// CompIDFromKey extracts the component name from a repository-relative key of the given document.
+// A legacy key without a component is the data component.
+func CompIDFromKey(docID, key string) string {
+	if key == docID {
+		return DefaultCompID
+	}
+	return strings.TrimPrefix(key, DocumentPrefix(docID))
+}
+
+// IsNotFound reports whether an S3 error means the object does not exist
+func IsNotFound(err error) bool {
+	var nf *types.NotFound
+	var nsk *types.NoSuchKey
+	return errors.As(err, &nf) || errors.As(err, &nsk)
+}
+
+// legacyComponent returns the object of a document stored under its bare docId, as all
+// documents were before they had components, or nil when there is none
+func legacyComponent(ctx context.Context, repo *Repository, docID string) (*types.Object, error) {
+	key := repo.LegacyKey(docID)
+	head, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &types.Object{
+		Key:          aws.String(key),
+		Size:         head.ContentLength,
+		ETag:         head.ETag,
+		LastModified: head.LastModified,
+	}, nil
+}
+
+// containsKey reports whether a list of objects contains the given key
+func containsKey(objects []types.Object, key string) bool {
+	return slices.ContainsFunc(objects, func(obj types.Object) bool { return aws.ToString(obj.Key) == key })
+}
+
+// ListComponents returns all S3 objects that belong to a document. A legacy object
+// stored under the bare docId is listed as the data component unless the document
+// has a data component of its own.
+func ListComponents(ctx context.Context, repo *Repository, docID string) ([]types.Object, error) {
+	var objects []types.Object
+
+	paginator := s3.NewListObjectsV2Paginator(repo.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(repo.Bucket),
+		Prefix: aws.String(repo.DocumentPrefix(docID)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
+	}
+
+	if !containsKey(objects, repo.ComponentKey(docID, DefaultCompID)) {
+		legacy, err := legacyComponent(ctx, repo, docID)
+		if err != nil {
+			return nil, err
+		}
+		if legacy != nil {
+			objects = append([]types.Object{*legacy}, objects...)
+		}
+	}
+	return objects, nil
+}
+
+// ResolveComponent finds the component to serve and returns its key and metadata.
+// If compID is empty the default component is used, falling back to the first
+// component of the document when no default component exists. The data component of
+// a document stored before documents had components is its legacy object.
+func ResolveComponent(ctx context.Context, repo *Repository, docID, compID string) (string, *s3.HeadObjectOutput, error) {
+	explicit := compID != ""
+	if !explicit {
+		compID = DefaultCompID
+	}
+
+	key := repo.ComponentKey(docID, compID)
+	head, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil || !IsNotFound(err) {
+		return key, head, err
+	}
+
+	if compID == DefaultCompID {
+		legacyKey := repo.LegacyKey(docID)
+		legacyHead, legacyErr := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(repo.Bucket),
+			Key:    aws.String(legacyKey),
+		})
+		if legacyErr == nil || !IsNotFound(legacyErr) {
+			return legacyKey, legacyHead, legacyErr
+		}
+	}
+	if explicit {
+		return key, head, err
+	}
+
+	objects, listErr := ListComponents(ctx, repo, docID)
+	if listErr != nil {
+		return key, nil, listErr
+	}
+	if len(objects) == 0 {
+		return key, nil, err
+	}
+
+	key = aws.ToString(objects[0].Key)
+	head, err = repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	return key, head, err
+}
+
+// DeleteDocument removes every component of a document and returns how many were deleted.
+// A legacy object is removed too, also when a data component hides it.
+func DeleteDocument(ctx context.Context, repo *Repository, docID string) (int, error) {
+	objects, err := ListComponents(ctx, repo, docID)
+	if err != nil {
+		return 0, err
+	}
+	if !containsKey(objects, repo.LegacyKey(docID)) {
+		legacy, err := legacyComponent(ctx, repo, docID)
+		if err != nil {
+			return 0, err
+		}
+		if legacy != nil {
+			objects = append(objects, *legacy)
+		}
+	}
+
+	deleted := 0
+	for start := 0; start < len(objects); start += maxDeleteBatch {
+		end := min(start+maxDeleteBatch, len(objects))
+
+		ids := make([]types.ObjectIdentifier, 0, end-start)
+		for _, obj := range objects[start:end] {
+			ids = append(ids, types.ObjectIdentifier{Key: obj.Key})
+		}
+
+		out, err := repo.Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(repo.Bucket),
+			Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return deleted + len(ids) - len(out.Errors), errors.New(aws.ToString(e.Key) + ": " + aws.ToString(e.Message))
+		}
+		deleted += len(ids)
+	}
+	return deleted, nil
+}
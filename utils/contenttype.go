@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// DefaultContentType is the content type of components whose type is neither declared nor recognised
+const DefaultContentType = "application/octet-stream"
+
+// sniffLen is the number of leading bytes http.DetectContentType looks at
+const sniffLen = 512
+
+// ResolveContentType returns the Content-Type to store for a component together with a
+// reader yielding the complete content. The media type declared by the client is used
+// when given; otherwise it is sniffed from the first bytes of body. The ArchiveLink
+// charset and version parameters, when set, replace those of the media type.
+func ResolveContentType(declared, charset, version string, body io.Reader) (string, io.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil || mediaType == DefaultContentType {
+		mediaType, params = "", nil
+	}
+
+	if mediaType == "" {
+		sniffed, r, err := sniffContentType(body)
+		if err != nil {
+			return "", nil, err
+		}
+		body = r
+		mediaType, params, _ = mime.ParseMediaType(sniffed)
+	}
+
+	if params == nil {
+		params = map[string]string{}
+	}
+	if charset != "" {
+		params["charset"] = charset
+	}
+	if version != "" {
+		params["version"] = version
+	}
+
+	contentType := mime.FormatMediaType(mediaType, params)
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+	return contentType, body, nil
+}
+
+// ContentTypeParams returns the charset and version parameters of a Content-Type
+func ContentTypeParams(contentType string) (charset, version string) {
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", ""
+	}
+	return params["charset"], params["version"]
+}
+
+// sniffContentType detects the content type of a stream from its first bytes. Seekable
+// streams are rewound so they can still be retried; others are wrapped in a buffered
+// reader that replays the inspected bytes.
+func sniffContentType(body io.Reader) (string, io.Reader, error) {
+	if rs, ok := body.(io.ReadSeeker); ok {
+		buf := make([]byte, sniffLen)
+		n, err := io.ReadFull(rs, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return "", nil, err
+		}
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return "", nil, err
+		}
+		return http.DetectContentType(buf[:n]), rs, nil
+	}
+
+	br := bufio.NewReaderSize(body, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", nil, err
+	}
+	return http.DetectContentType(head), br, nil
+}
@@ -0,0 +1,18 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSniffingReportsBodyTooLarge(t *testing.T) {
+	// The body arrives in small reads and reaches the limit within the bytes read for sniffing
+	body := &limitedBody{r: iotest.OneByteReader(strings.NewReader(strings.Repeat("A", 1024))), remaining: 100}
+
+	_, _, err := ResolveContentType("", "", "", body)
+	if !errors.Is(err, ErrBodyTooLarge) {
+		t.Fatalf("Expected ErrBodyTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Maximum lengths of ArchiveLink document and component identifiers
+const (
+	MaxDocIDLength  = 40
+	MaxCompIDLength = 250
+)
+
+// Errors returned for identifiers that break the ArchiveLink rules
+var (
+	ErrInvalidDocID  = errors.New("invalid docId")
+	ErrInvalidCompID = errors.New("invalid compId")
+)
+
+// ValidateDocID checks that a docId is non-empty, at most MaxDocIDLength characters long
+// and consists of ASCII letters, digits, '-', '_' and '.' only
+func ValidateDocID(docID string) error {
+	return validateIdentifier(docID, MaxDocIDLength, ErrInvalidDocID)
+}
+
+// ValidateCompID checks a compId like a docId, allowing up to MaxCompIDLength characters
+func ValidateCompID(compID string) error {
+	return validateIdentifier(compID, MaxCompIDLength, ErrInvalidCompID)
+}
+
+// validateIdentifier checks an identifier used as one segment of an S3 key
+func validateIdentifier(id string, maxLength int, errInvalid error) error {
+	switch {
+	case id == "":
+		return fmt.Errorf("%w: empty", errInvalid)
+	case len(id) > maxLength:
+		return fmt.Errorf("%w: longer than %d characters", errInvalid, maxLength)
+	case id == "." || id == "..":
+		return fmt.Errorf("%w: %q", errInvalid, id)
+	}
+	for i := 0; i < len(id); i++ {
+		b := id[i]
+		if 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || '0' <= b && b <= '9' || b == '-' || b == '_' || b == '.' {
+			continue
+		}
+		return fmt.Errorf("%w: character %q not allowed", errInvalid, b)
+	}
+	return nil
+}
+
+// DocKeyID validates a docId and derives the form used in the S3 keys of the repository
+// according to its docIdCase policy. Every command derives its keys through it.
+func (r *Repository) DocKeyID(docID string) (string, error) {
+	if err := ValidateDocID(docID); err != nil {
+		return "", err
+	}
+	if r.Config.DocIDCase == s3_adapter_config.DocIDCasePreserve {
+		return docID, nil
+	}
+	return strings.ToUpper(docID), nil
+}
+
+// DocDisplayID returns the docId recorded in the attributes of a component: the key form,
+// except with the insensitive policy, which keeps the spelling sent by the client
+func (r *Repository) DocDisplayID(docID string) string {
+	if r.Config.DocIDCase == s3_adapter_config.DocIDCaseInsensitive {
+		return docID
+	}
+	keyID, err := r.DocKeyID(docID)
+	if err != nil {
+		return docID
+	}
+	return keyID
+}
+
+// NormalizeDocID returns a middleware that validates the docId and compId of a request,
+// keeps the reserved key prefixes out of reach and derives the docId used in S3 keys,
+// which handlers read with DocID
+func NormalizeDocID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		if compID := c.Query("compId"); compID != "" {
+			if err := ValidateCompID(compID); err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(err.Error())
+			}
+		}
+
+		docID := c.Query("docId")
+		if docID == "" {
+			return c.Next()
+		}
+		if repo := CurrentRepository(c); repo != nil {
+			keyID, err := repo.DocKeyID(docID)
+			if err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(err.Error())
+			}
+			docID = keyID
+		}
+		if IsReservedDocID(docID) {
+			logRequest(c, start, "ERROR=reserved docId")
+			return c.Status(http.StatusBadRequest).SendString("reserved docId")
+		}
+
+		// Streamed responses use the docId after the Ctx has been released, so it must not
+		// point into the request buffer as the query value does
+		c.Locals("docId", strings.Clone(docID))
+		return c.Next()
+	}
+}
+
+// DocID returns the docId of a request in the form used in S3 keys, or an empty string
+// when the request names none
+func DocID(c *fiber.Ctx) string {
+	docID, _ := c.Locals("docId").(string)
+	return docID
+}
+
+// DocIDMigration reports the outcome of MigrateDocIDs
+type DocIDMigration struct {
+	DryRun    bool     `json:"dryRun"`
+	Scanned   int      `json:"scanned"`
+	Migrated  []string `json:"migrated"`
+	Conflicts []string `json:"conflicts"`
+	Invalid   []string `json:"invalid"`
+}
+
+// MigrateDocIDs moves components stored under a docId that differs from the key form of
+// the repository's docIdCase policy (e.g. written before the policy applied to every
+// command) to their normalised key. Legacy documents stored under their bare docId, as
+// all documents were before they had components, become the data component of their
+// normalised docId. Components whose target key is already taken are
+// reported as conflicts and left in place; keys with an invalid docId are only reported.
+// With dryRun nothing is changed. Objects larger than 5 GiB cannot be moved with a
+// single CopyObject and are reported as errors.
+func MigrateDocIDs(ctx context.Context, repo *Repository, dryRun bool) (*DocIDMigration, error) {
+	report := &DocIDMigration{DryRun: dryRun, Migrated: []string{}, Conflicts: []string{}, Invalid: []string{}}
+
+	paginator := s3.NewListObjectsV2Paginator(repo.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(repo.Bucket),
+		Prefix: aws.String(repo.Prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return report, err
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			rel := repo.RelKey(key)
+			if IsReservedKey(rel) {
+				continue
+			}
+			report.Scanned++
+
+			docID, compID, found := strings.Cut(rel, "/")
+			legacy := !found
+			if legacy {
+				compID = DefaultCompID
+			}
+			keyID, err := repo.DocKeyID(docID)
+			if err != nil {
+				report.Invalid = append(report.Invalid, rel)
+				continue
+			}
+			if keyID == docID && !legacy {
+				continue
+			}
+
+			target := repo.ComponentKey(keyID, compID)
+			_, err = repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+				Bucket: aws.String(repo.Bucket),
+				Key:    aws.String(target),
+			})
+			if err == nil {
+				report.Conflicts = append(report.Conflicts, rel)
+				continue
+			}
+			if !IsNotFound(err) {
+				return report, err
+			}
+
+			if !dryRun {
+				if err := moveComponent(ctx, repo, key, target, repo.DocDisplayID(docID)); err != nil {
+					return report, fmt.Errorf("%s: %w", rel, err)
+				}
+			}
+			report.Migrated = append(report.Migrated, rel+" -> "+repo.RelKey(target))
+		}
+	}
+	return report, nil
+}
+
+// moveComponent copies a component to a new key, recording its new docId in its
+// attributes and tags, and deletes the original
+func moveComponent(ctx context.Context, repo *Repository, key, target, docID string) error {
+	head, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return err
+	}
+	tags, err := GetObjectTags(ctx, repo, key)
+	if err != nil {
+		return err
+	}
+
+	metadata := make(map[string]string, len(head.Metadata)+1)
+	for k, v := range head.Metadata {
+		metadata[k] = v
+	}
+	metadata[metaDocID] = docID
+	tags["docId"] = docID
+
+	_, err = repo.Client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:            aws.String(repo.Bucket),
+		Key:               aws.String(target),
+		CopySource:        aws.String(url.PathEscape(repo.Bucket + "/" + key)),
+		ContentType:       head.ContentType,
+		Metadata:          metadata,
+		MetadataDirective: types.MetadataDirectiveReplace,
+		Tagging:           aws.String(EncodeTags(tags)),
+		TaggingDirective:  types.TaggingDirectiveReplace,
+		StorageClass:      types.StorageClass(repo.Config.StorageClass),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
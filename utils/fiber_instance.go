@@ -20,5 +20,10 @@ func CreateNewFiberAppInstance() *fiber.App {
 		AppName:       cfg.FiberConfig.AppName,
 		ReadTimeout:   cfg.FiberConfig.ReadTimeout,
 		BodyLimit:     cfg.FiberConfig.BodyLimit,
+
+		// Hand request bodies to the handlers as they arrive instead of buffering them, so
+		// uploads are piped to S3 with memory bounded by the uploader's part buffers
+		StreamRequestBody:            true,
+		DisablePreParseMultipartForm: true,
 	})
 }
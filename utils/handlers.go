@@ -1,134 +1,1188 @@
 package utils
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
 )
 
 // ---------------------- UPLOAD ----------------------
 
-// HandleCreateWithCtx uploads a file to S3 using a cancellable context
-func HandleCreateWithCtx(ctx context.Context, s3Client *s3.Client, bucketName string) fiber.Handler {
+// HandleCreateWithCtx uploads a file to S3 using a cancellable context. The file is the first
+// file part of a multipart form, or the request body itself for any other Content-Type.
+func HandleCreateWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		updateMaxMemory()
 		start := time.Now()
 
-		docID := c.Query("docId")
+		docID := DocID(c)
 		if docID == "" {
 			logRequest(c, start, "ERROR=missing docId")
 			return c.Status(http.StatusBadRequest).SendString("docId required")
 		}
 
-		fileReader, filename, err := ExtractFileStream(c)
+		extraTags, err := RequestTags(c, repo)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		// The content is the first file part of a multipart form or else the request body
+		var fileReader io.Reader
+		var declaredType, filename string
+		if IsMultipartForm(c) {
+			var part *multipart.Part
+			fileReader, part, err = ExtractFileStream(c)
+			if errors.Is(err, ErrBodyTooLarge) {
+				logRequest(c, start, "ERROR=request body too large")
+				return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+			}
+			if err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("file read error: %v", err))
+			}
+			declaredType, filename = part.Header.Get("Content-Type"), part.FileName()
+		} else {
+			fileReader, declaredType, filename = ExtractBodyStream(c)
+		}
+
+		// The declared Content-Type may be refined by the charset and version parameters.
+		// Sniffing reads the first bytes of the body, which may already exceed the body limit.
+		contentType, fileReader, err := ResolveContentType(declaredType, c.Query("charset"), c.Query("version"), fileReader)
+		if errors.Is(err, ErrBodyTooLarge) {
+			logRequest(c, start, "ERROR=request body too large")
+			return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+		}
 		if err != nil {
 			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
 			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("file read error: %v", err))
 		}
 
-		contRep := c.Query("contRep")
-		if contRep == "" {
-			logRequest(c, start, "ERROR=missing contRep")
-			return c.Status(http.StatusBadRequest).SendString("contRep required")
+		compID := c.Query("compId")
+		if compID == "" {
+			compID = DefaultCompID
+		}
+
+		meta := NewComponentMeta(repo.Name, repo.DocDisplayID(c.Query("docId")), compID, filename, time.Now())
+		tags, err := ComponentTags(meta, extraTags)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		if filename == "" {
+			filename = fmt.Sprintf("doc-%d", time.Now().Unix())
+		}
+
+		// Upload using the cancellable context, refusing to replace an existing component
+		attrs := ObjectAttributes{ContentType: contentType, Metadata: meta.Metadata(), Tags: tags}
+		err = CreateFileInS3Stream(ctx, repo, repo.Uploader, repo.ComponentKey(docID, compID), fileReader, attrs)
+		if errors.Is(err, ErrAlreadyExists) {
+			logRequest(c, start, "ERROR=document already exists")
+			return c.Status(http.StatusForbidden).SendString(fmt.Sprintf("already exists: %s/%s", docID, compID))
+		}
+		if errors.Is(err, ErrBodyTooLarge) {
+			logRequest(c, start, "ERROR=request body too large")
+			return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("upload cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("upload error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("UPLOADED compId=%s contentType=%s", compID, contentType))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("OK %s", filename))
+	}
+}
+
+// mCreateConcurrency limits how many documents of one mCreate request are uploaded at once
+const mCreateConcurrency = 4
+
+// MCreateResult describes the outcome of one document of an mCreate request
+type MCreateResult struct {
+	DocID  string `json:"docId"`
+	CompID string `json:"compId"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HandleMCreateWithCtx uploads several documents carried in one multipart body using a
+// cancellable context. Each file part names its document with the X-docId and X-compId
+// part headers (falling back to the form field name and the default component).
+func HandleMCreateWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		extraTags, err := RequestTags(c, repo)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		form, err := ReadMultipartForm(c)
+		if errors.Is(err, ErrBodyTooLarge) {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+		}
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+		defer form.RemoveAll()
+
+		var files []*multipart.FileHeader
+		for _, headers := range form.File {
+			files = append(files, headers...)
+		}
+		if len(files) == 0 {
+			logRequest(c, start, "ERROR=no file part found")
+			return c.Status(http.StatusBadRequest).SendString("no file part found")
+		}
+
+		results := make([]MCreateResult, len(files))
+		sem := make(chan struct{}, mCreateConcurrency)
+		var wg sync.WaitGroup
+
+		for i, file := range files {
+			docID := file.Header.Get("X-docId")
+			if docID == "" {
+				docID = formFieldName(file)
+			}
+			compID := file.Header.Get("X-compId")
+			if compID == "" {
+				compID = DefaultCompID
+			}
+			results[i] = MCreateResult{DocID: docID, CompID: compID}
+			if docID == "" {
+				results[i].Status = http.StatusBadRequest
+				results[i].Error = "docId required"
+				continue
+			}
+			keyID, err := repo.DocKeyID(docID)
+			if err == nil {
+				err = ValidateCompID(compID)
+			}
+			if err == nil && IsReservedDocID(keyID) {
+				err = errors.New("reserved docId")
+			}
+			if err != nil {
+				results[i].Status = http.StatusBadRequest
+				results[i].Error = err.Error()
+				continue
+			}
+			results[i].DocID = keyID
+			displayID := repo.DocDisplayID(docID)
+
+			wg.Add(1)
+			go func(res *MCreateResult, file *multipart.FileHeader) {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+
+				f, err := file.Open()
+				if err != nil {
+					res.Status = http.StatusBadRequest
+					res.Error = fmt.Sprintf("open file: %v", err)
+					return
+				}
+				defer f.Close()
+
+				contentType, body, err := ResolveContentType(file.Header.Get("Content-Type"), "", "", f)
+				if err != nil {
+					res.Status = http.StatusBadRequest
+					res.Error = fmt.Sprintf("read file: %v", err)
+					return
+				}
+				meta := NewComponentMeta(repo.Name, displayID, res.CompID, file.Filename, time.Now())
+				tags, err := ComponentTags(meta, extraTags)
+				if err != nil {
+					res.Status = http.StatusBadRequest
+					res.Error = err.Error()
+					return
+				}
+				attrs := ObjectAttributes{ContentType: contentType, Metadata: meta.Metadata(), Tags: tags}
+
+				err = CreateFileInS3Stream(ctx, repo, repo.Uploader, repo.ComponentKey(res.DocID, res.CompID), body, attrs)
+				if errors.Is(err, ErrAlreadyExists) {
+					res.Status = http.StatusForbidden
+					res.Error = err.Error()
+					return
+				}
+				if err != nil {
+					res.Status = http.StatusInternalServerError
+					res.Error = fmt.Sprintf("upload error: %v", err)
+					return
+				}
+				res.Status = http.StatusCreated
+			}(&results[i], file)
+		}
+		wg.Wait()
+
+		select {
+		case <-ctx.Done():
+			logRequest(c, start, "CANCELLED")
+			return c.Status(fiber.StatusRequestTimeout).SendString("upload cancelled")
+		default:
+		}
+
+		failed := 0
+		for _, res := range results {
+			if res.Status != http.StatusCreated {
+				failed++
+			}
+		}
+
+		status := http.StatusOK
+		if failed > 0 {
+			status = http.StatusMultiStatus
+		}
+
+		logRequest(c, start, fmt.Sprintf("MCREATE documents=%d failed=%d", len(results), failed))
+		return c.Status(status).JSON(results)
+	}
+}
+
+// formFieldName returns the form field name of a multipart file part
+func formFieldName(file *multipart.FileHeader) string {
+	_, params, err := mime.ParseMediaType(file.Header.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	return params["name"]
+}
+
+// ---------------------- RESUMABLE UPLOAD ----------------------
+
+// UploadStatus describes a resumable upload and the chunks that have arrived so far
+type UploadStatus struct {
+	UploadID string          `json:"uploadId"`
+	DocID    string          `json:"docId"`
+	CompID   string          `json:"compId"`
+	Chunks   []UploadedChunk `json:"chunks"`
+	Size     int64           `json:"size"`
+	Expires  time.Time       `json:"expires"`
+}
+
+// newUploadStatus returns the status of an upload session with the given chunks
+func newUploadStatus(session *UploadSession, chunks []UploadedChunk) UploadStatus {
+	status := UploadStatus{UploadID: session.UploadID, DocID: session.DocID, CompID: session.CompID, Chunks: chunks, Expires: session.Expires}
+	for _, chunk := range chunks {
+		status.Size += chunk.Size
+	}
+	return status
+}
+
+// uploadError sends the response for a failed resumable upload command
+func uploadError(ctx context.Context, c *fiber.Ctx, start time.Time, command string, err error) error {
+	logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		return c.Status(http.StatusNotFound).SendString(err.Error())
+	case errors.Is(err, ErrUploadExpired):
+		return c.Status(http.StatusGone).SendString(err.Error())
+	case errors.Is(err, ErrAlreadyExists):
+		return c.Status(http.StatusForbidden).SendString(err.Error())
+	case errors.Is(err, ErrInvalidChunk), errors.Is(err, ErrNoChunks), errors.Is(err, ErrChunkTooSmall), errors.Is(err, ErrMissingChunk):
+		return c.Status(http.StatusBadRequest).SendString(err.Error())
+	case errors.Is(err, ErrBodyTooLarge):
+		return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+	}
+	select {
+	case <-ctx.Done():
+		return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+	default:
+		return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("%s error: %v", command, err))
+	}
+}
+
+// HandleInitUploadWithCtx starts a resumable upload of a component using a cancellable
+// context. The attributes and tags are those create would store; as the content is not
+// available yet, its Content-Type must be declared on this request.
+func HandleInitUploadWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		extraTags, err := RequestTags(c, repo)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		compID := c.Query("compId")
+		if compID == "" {
+			compID = DefaultCompID
+		}
+
+		contentType := DeclaredContentType(c.Get(fiber.HeaderContentType), c.Query("charset"), c.Query("version"))
+		meta := NewComponentMeta(repo.Name, repo.DocDisplayID(c.Query("docId")), compID, RequestFilename(c), time.Now())
+		tags, err := ComponentTags(meta, extraTags)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		attrs := ObjectAttributes{ContentType: contentType, Metadata: meta.Metadata(), Tags: tags}
+		session, err := InitiateUpload(ctx, repo, docID, compID, attrs)
+		if err != nil {
+			return uploadError(ctx, c, start, "initUpload", err)
+		}
+
+		logRequest(c, start, fmt.Sprintf("INITUPLOAD uploadId=%s compId=%s", session.UploadID, compID))
+		return c.Status(http.StatusCreated).JSON(newUploadStatus(session, []UploadedChunk{}))
+	}
+}
+
+// HandleUploadChunkWithCtx stores a numbered chunk of a resumable upload using a cancellable
+// context. Sending a chunk again replaces it.
+func HandleUploadChunkWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		chunk, err := strconv.ParseInt(c.Query("chunk"), 10, 32)
+		if err != nil {
+			return uploadError(ctx, c, start, "uploadChunk", ErrInvalidChunk)
+		}
+
+		session, err := LoadUploadSession(ctx, repo, c.Query("uploadId"))
+		if err != nil {
+			return uploadError(ctx, c, start, "uploadChunk", err)
+		}
+
+		uploaded, err := UploadChunk(ctx, repo, session, int32(chunk), RequestBodyStream(c))
+		if err != nil {
+			return uploadError(ctx, c, start, "uploadChunk", err)
+		}
+
+		logRequest(c, start, fmt.Sprintf("UPLOADCHUNK uploadId=%s chunk=%d size=%d", session.UploadID, chunk, uploaded.Size))
+		return c.Status(http.StatusOK).JSON(uploaded)
+	}
+}
+
+// HandleUploadStatusWithCtx reports which chunks of a resumable upload have arrived using a
+// cancellable context
+func HandleUploadStatusWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		session, err := LoadUploadSession(ctx, repo, c.Query("uploadId"))
+		if err != nil {
+			return uploadError(ctx, c, start, "uploadStatus", err)
+		}
+		chunks, err := ListUploadedChunks(ctx, repo, session)
+		if err != nil {
+			return uploadError(ctx, c, start, "uploadStatus", err)
+		}
+
+		logRequest(c, start, fmt.Sprintf("UPLOADSTATUS uploadId=%s chunks=%d", session.UploadID, len(chunks)))
+		return c.Status(http.StatusOK).JSON(newUploadStatus(session, chunks))
+	}
+}
+
+// HandleCompleteUploadWithCtx assembles the chunks of a resumable upload into the component
+// using a cancellable context. The optional chunks parameter is the number of chunks the
+// client sent.
+func HandleCompleteUploadWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		var expected int64
+		if c.Query("chunks") != "" {
+			var err error
+			if expected, err = strconv.ParseInt(c.Query("chunks"), 10, 32); err != nil || expected < 1 {
+				return uploadError(ctx, c, start, "completeUpload", ErrInvalidChunk)
+			}
+		}
+
+		session, err := LoadUploadSession(ctx, repo, c.Query("uploadId"))
+		if err != nil {
+			return uploadError(ctx, c, start, "completeUpload", err)
+		}
+		size, err := CompleteUpload(ctx, repo, session, int32(expected))
+		if err != nil {
+			return uploadError(ctx, c, start, "completeUpload", err)
+		}
+
+		logRequest(c, start, fmt.Sprintf("UPLOADED uploadId=%s compId=%s size=%d", session.UploadID, session.CompID, size))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("OK %s/%s", session.DocID, session.CompID))
+	}
+}
+
+// HandleAbortUploadWithCtx discards a resumable upload and its chunks using a cancellable context
+func HandleAbortUploadWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		session, err := LoadUploadSession(ctx, repo, c.Query("uploadId"))
+		if err != nil {
+			return uploadError(ctx, c, start, "abortUpload", err)
+		}
+		if err := AbortUpload(ctx, repo, session); err != nil {
+			return uploadError(ctx, c, start, "abortUpload", err)
+		}
+
+		logRequest(c, start, fmt.Sprintf("ABORTUPLOAD uploadId=%s", session.UploadID))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("ABORTED %s", session.UploadID))
+	}
+}
+
+// ---------------------- APPEND ----------------------
+
+// HandleAppendWithCtx appends the request body to an existing component using a cancellable context
+func HandleAppendWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		compID := c.Query("compId")
+		if compID == "" {
+			compID = DefaultCompID
+		}
+		key := repo.ComponentKey(docID, compID)
+
+		meta, head, err := LoadComponentMeta(ctx, repo, key)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %v", err))
+			}
+		}
+
+		// The extra tags of the component are kept
+		oldTags, _ := GetObjectTags(ctx, repo, key)
+		meta.Touch(time.Now())
+		tags, err := ComponentTags(meta, ExtraTags(oldTags))
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+		attrs := ObjectAttributes{ContentType: aws.ToString(head.ContentType), Metadata: meta.Metadata(), Tags: tags}
+
+		err = AppendToS3Object(ctx, repo, key, RequestBodyStream(c), attrs)
+		if errors.Is(err, ErrBodyTooLarge) {
+			logRequest(c, start, "ERROR=request body too large")
+			return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+		}
+		if errors.Is(err, ErrComponentChanged) {
+			logRequest(c, start, "ERROR=component changed during append")
+			return c.Status(http.StatusConflict).SendString(err.Error())
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("append cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("append error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("APPENDED compId=%s size=%d", compID, c.Request().Header.ContentLength()))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("APPENDED %s/%s", docID, compID))
+	}
+}
+
+// ---------------------- UPDATE ----------------------
+
+// HandleUpdateWithCtx replaces existing components and adds new ones to a document using
+// a cancellable context. Creation dates of replaced components are kept. The file parts are
+// staged as they arrive and swapped in only once the whole body has been received, so a
+// failed update leaves the document unchanged.
+func HandleUpdateWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		extraTags, err := RequestTags(c, repo)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		objects, err := ListComponents(ctx, repo, docID)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("update error: %v", err))
+			}
+		}
+		if len(objects) == 0 {
+			logRequest(c, start, "ERROR=document not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", docID))
+		}
+
+		// existing maps the compIds of the document to their keys
+		existing := make(map[string]string, len(objects))
+		for _, obj := range objects {
+			key := aws.ToString(obj.Key)
+			existing[repo.CompIDFromKey(docID, key)] = key
+		}
+
+		mr, err := MultipartReader(c)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		staging := NewStaging(repo)
+		defer staging.Remove()
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if errors.Is(err, ErrBodyTooLarge) {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+			}
+			if err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("multipart reader: %v", err))
+			}
+			if part.FileName() == "" {
+				continue
+			}
+
+			err = staging.Stage(ctx, part)
+			if errors.Is(err, ErrBodyTooLarge) {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusRequestEntityTooLarge).SendString(err.Error())
+			}
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					logRequest(c, start, "CANCELLED")
+					return c.Status(fiber.StatusRequestTimeout).SendString("update cancelled")
+				default:
+					logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+					return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("upload error: %v", err))
+				}
+			}
+		}
+		if len(staging.Parts) == 0 {
+			logRequest(c, start, "ERROR=no file part found")
+			return c.Status(http.StatusBadRequest).SendString("no file part found")
+		}
+
+		// Resolve and validate all compIds before anything is swapped in
+		replaced, added := 0, 0
+		for i := range staging.Parts {
+			part := &staging.Parts[i]
+			compID := part.Header
+			if compID == "" && len(staging.Parts) == 1 {
+				compID = c.Query("compId")
+			}
+			if compID == "" {
+				compID = part.FieldName
+			}
+			if compID == "" {
+				compID = DefaultCompID
+			}
+			if err := ValidateCompID(compID); err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(err.Error())
+			}
+
+			// Replaced components keep their creation date, docId spelling and extra tags
+			meta := NewComponentMeta(repo.Name, repo.DocDisplayID(c.Query("docId")), compID, part.Filename, time.Now())
+			componentTags := map[string]string{}
+			if key, ok := existing[compID]; ok {
+				old, _, err := LoadComponentMeta(ctx, repo, key)
+				if err == nil && old.DateC != "" {
+					meta.DateC, meta.TimeC = old.DateC, old.TimeC
+				}
+				if err == nil && old.DocID != "" {
+					meta.DocID = old.DocID
+				}
+				oldTags, _ := GetObjectTags(ctx, repo, key)
+				componentTags = ExtraTags(oldTags)
+				replaced++
+			} else {
+				added++
+			}
+			for k, v := range extraTags {
+				componentTags[k] = v
+			}
+			tags, err := ComponentTags(meta, componentTags)
+			if err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(err.Error())
+			}
+			part.CompID = compID
+			part.Attrs = ObjectAttributes{ContentType: part.ContentType, Metadata: meta.Metadata(), Tags: tags}
+		}
+
+		if err := staging.SwapIn(ctx, docID, existing); err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("update cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("upload error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("UPDATED replaced=%d added=%d", replaced, added))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("UPDATED %s", docID))
+	}
+}
+
+// ---------------------- DELETE ----------------------
+
+// HandleDeleteWithCtx deletes a component, or the whole document when compId is omitted,
+// using a cancellable context
+func HandleDeleteWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		compID := c.Query("compId")
+		if compID == "" {
+			n, err := DeleteDocument(ctx, repo, docID)
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					logRequest(c, start, "CANCELLED")
+					return c.Status(fiber.StatusRequestTimeout).SendString("delete cancelled")
+				default:
+					logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+					return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("delete error: %v", err))
+				}
+			}
+			if n == 0 {
+				logRequest(c, start, "ERROR=document not found")
+				return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", docID))
+			}
+
+			logRequest(c, start, fmt.Sprintf("DELETED components=%d", n))
+			return c.Status(http.StatusOK).SendString(fmt.Sprintf("DELETED %s", docID))
+		}
+
+		// DeleteObject succeeds for missing keys, so check the component exists first
+		key, _, err := ResolveComponent(ctx, repo, docID, compID)
+		if IsNotFound(err) {
+			logRequest(c, start, "ERROR=component not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s/%s", docID, compID))
+		}
+		if err == nil {
+			_, err = repo.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(repo.Bucket),
+				Key:    aws.String(key),
+			})
+		}
+		if err == nil && key != repo.LegacyKey(docID) && repo.CompIDFromKey(docID, key) == DefaultCompID {
+			// A legacy object hidden by the data component would take its place
+			_, err = repo.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(repo.Bucket),
+				Key:    aws.String(repo.LegacyKey(docID)),
+			})
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("delete cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("delete error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("DELETED compId=%s", compID))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("DELETED %s/%s", docID, compID))
+	}
+}
+
+// ---------------------- DOWNLOAD ----------------------
+
+// HandleGetWithCtx downloads a document component from S3 using a cancellable context
+func HandleGetWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		// Read component metadata first
+		key, head, err := ResolveComponent(ctx, repo, docID, c.Query("compId"))
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %v", err))
+			}
+		}
+
+		filename := componentMeta(ctx, repo, key, head.Metadata).Filename
+		if filename == "" {
+			filename = docID
+		}
+
+		size := aws.ToInt64(head.ContentLength)
+		byteRange, err := RequestedRange(c, size)
+		if errors.Is(err, ErrRangeNotSatisfiable) {
+			logRequest(c, start, "ERROR=range not satisfiable")
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.Status(http.StatusRequestedRangeNotSatisfiable).SendString("range not satisfiable")
+		}
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(repo.Bucket),
+			Key:    aws.String(key),
+		}
+		if byteRange != nil {
+			input.Range = aws.String(byteRange.S3Range())
+		}
+
+		out, err := repo.Client.GetObject(ctx, input)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %v", err))
+			}
+		}
+
+		contentType := aws.ToString(head.ContentType)
+		if contentType == "" {
+			contentType = DefaultContentType
+		}
+		c.Set(fiber.HeaderContentType, contentType)
+		c.Response().Header.Set("Content-Disposition", ContentDisposition("attachment", filename))
+		c.Set(fiber.HeaderAcceptRanges, "bytes")
+		if byteRange != nil {
+			c.Set(fiber.HeaderContentRange, byteRange.ContentRange(size))
+			c.Status(http.StatusPartialContent)
+		} else {
+			c.Status(http.StatusOK)
+		}
+
+		// The S3 body is sent while the response is written and closed afterwards, so only
+		// the copy buffer is held in memory whatever the size of the component
+		length := aws.ToInt64(out.ContentLength)
+		reqLog := newRequestLog(c)
+		SendStream(c, out.Body, length, func(sent int64) {
+			if sent < length {
+				reqLog.log(start, fmt.Sprintf("ERROR=download of %s aborted after %d of %d bytes", key, sent, length))
+				return
+			}
+			reqLog.log(start, fmt.Sprintf("SERVED %s size=%d", filename, sent))
+		})
+		return nil
+	}
+}
+
+// HandleDocGetWithCtx streams all components of a document as multipart/form-data
+// using a cancellable context. Components are fetched from S3 one at a time while
+// the response is written, so they are never buffered in memory together.
+func HandleDocGetWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		objects, err := ListComponents(ctx, repo, docID)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("docGet error: %v", err))
+			}
+		}
+		if len(objects) == 0 {
+			logRequest(c, start, "ERROR=document not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", docID))
+		}
+
+		boundary := uuid.New().String()
+		c.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		c.Set("X-docId", docID)
+		c.Set("X-contRep", repo.Name)
+		c.Set("X-numberComps", strconv.Itoa(len(objects)))
+		c.Status(http.StatusOK)
+
+		// The writer runs after the handler has returned and the Ctx has been released,
+		// so it logs from a copy of the request fields. The request stays active until
+		// the writer is done.
+		reqLog := newRequestLog(c)
+		done := HoldRequest(c)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer done()
+			mw := multipart.NewWriter(w)
+			if err := mw.SetBoundary(boundary); err != nil {
+				reqLog.log(start, fmt.Sprintf("ERROR=%v", err))
+				return
+			}
+
+			var total int64
+			for _, obj := range objects {
+				n, err := writeComponentPart(ctx, repo, docID, aws.ToString(obj.Key), mw)
+				total += n
+				if err != nil {
+					reqLog.log(start, fmt.Sprintf("ERROR streaming %s: %v", aws.ToString(obj.Key), err))
+					return
+				}
+				// Push the part to the client before fetching the next component
+				if err := w.Flush(); err != nil {
+					reqLog.log(start, fmt.Sprintf("ERROR=%v", err))
+					return
+				}
+			}
+
+			if err := mw.Close(); err != nil {
+				reqLog.log(start, fmt.Sprintf("ERROR=%v", err))
+				return
+			}
+			reqLog.log(start, fmt.Sprintf("DOCGET components=%d size=%d", len(objects), total))
+		})
+		return nil
+	}
+}
+
+// writeComponentPart streams one component from S3 into a multipart part
+func writeComponentPart(ctx context.Context, repo *Repository, docID, key string, mw *multipart.Writer) (int64, error) {
+	out, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, err
+	}
+	defer out.Body.Close()
+
+	meta := componentMeta(ctx, repo, key, out.Metadata)
+
+	compID := repo.CompIDFromKey(docID, key)
+	contentType := aws.ToString(out.ContentType)
+	if contentType == "" {
+		contentType = DefaultContentType
+	}
+
+	header := textproto.MIMEHeader{}
+	header.Set("Content-Disposition", fmt.Sprintf("form-data; name=%q; filename=%q", compID, compID))
+	header.Set("Content-Type", contentType)
+	header.Set("X-compId", compID)
+	header.Set("X-Content-Length", strconv.FormatInt(aws.ToInt64(out.ContentLength), 10))
+	for name, v := range map[string]string{"X-dateC": meta.DateC, "X-timeC": meta.TimeC, "X-dateM": meta.DateM, "X-timeM": meta.TimeM} {
+		if v != "" {
+			header.Set(name, v)
+		}
+	}
+
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(part, out.Body)
+}
+
+// ---------------------- INFO ----------------------
+
+// HandleInfoWithCtx describes a document and its components in the ArchiveLink info
+// format using a cancellable context. When compId is set only that component is
+// described. The response is multipart/form-data, ASCII with resultAs=ascii, or JSON
+// when the client explicitly accepts application/json.
+func HandleInfoWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
+
+		doc, err := LoadDocumentInfo(ctx, repo, docID, c.Query("compId"))
+		if errors.Is(err, ErrDocumentNotFound) {
+			logRequest(c, start, "ERROR=document not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", docID))
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("info error: %v", err))
+			}
 		}
-		docID = strings.ToUpper(docID)
+		doc.PVersion = PVersion(c)
 
-		rootDocTags := map[string]string{
-			"contRep":  contRep,
-			"docId":    docID,
-			"filename": filename,
-			"X-dateC":  time.Now().Format("2006-01-02"),
-			"X-timeC":  time.Now().Format("15:04:05"),
-			"X-dateM":  time.Now().Format("2006-01-02"),
-			"X-timeM":  time.Now().Format("15:04:05"),
+		if strings.Contains(c.Get("Accept"), fiber.MIMEApplicationJSON) {
+			logRequest(c, start, fmt.Sprintf("INFO json components=%d", doc.NumberComps))
+			return c.Status(fiber.StatusOK).JSON(doc)
 		}
 
-		if filename == "" {
-			filename = fmt.Sprintf("doc-%d", time.Now().Unix())
+		for name, value := range doc.Headers() {
+			c.Set(name, value)
 		}
 
-		uploader := CreateS3Uploader(s3Client)
+		if c.Query("resultAs") == "ascii" {
+			c.Set("Content-Type", fiber.MIMETextPlainCharsetUTF8)
+			logRequest(c, start, fmt.Sprintf("INFO ascii components=%d", doc.NumberComps))
+			return c.Status(fiber.StatusOK).SendString(doc.ASCIIBody())
+		}
+
+		boundary := uuid.New().String()
+		body, err := doc.MultipartBody(boundary)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("info error: %v", err))
+		}
+		c.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		logRequest(c, start, fmt.Sprintf("INFO components=%d", doc.NumberComps))
+		return c.Status(fiber.StatusOK).Send(body)
+	}
+}
+
+// ---------------------- TAGS ----------------------
+
+// HandleGetTagsWithCtx returns the tags of the components of a document, or of the
+// component named by compId, as JSON keyed by compId using a cancellable context
+func HandleGetTagsWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		docID := DocID(c)
+		if docID == "" {
+			logRequest(c, start, "ERROR=missing docId")
+			return c.Status(http.StatusBadRequest).SendString("docId required")
+		}
 
-		// Upload using the cancellable context
-		err = UploadFileToS3Stream(ctx, uploader, bucketName, docID, fileReader, rootDocTags)
+		keys, err := tagTargets(ctx, repo, docID, c.Query("compId"))
+		result := make(map[string]map[string]string, len(keys))
+		for _, key := range keys {
+			tags, tagErr := GetObjectTags(ctx, repo, key)
+			if tagErr != nil {
+				err = tagErr
+				break
+			}
+			result[repo.CompIDFromKey(docID, key)] = tags
+		}
+		if errors.Is(err, ErrDocumentNotFound) || IsNotFound(err) {
+			logRequest(c, start, "ERROR=document not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", docID))
+		}
 		if err != nil {
 			select {
 			case <-ctx.Done():
 				logRequest(c, start, "CANCELLED")
-				return c.Status(fiber.StatusRequestTimeout).SendString("upload cancelled")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
 			default:
 				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
-				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("upload error: %v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("getTags error: %v", err))
 			}
 		}
 
-		logRequest(c, start, "UPLOADED")
-		return c.Status(http.StatusOK).SendString(fmt.Sprintf("OK %s", filename))
+		logRequest(c, start, fmt.Sprintf("GETTAGS components=%d", len(result)))
+		return c.Status(http.StatusOK).JSON(result)
 	}
 }
 
-// ---------------------- DELETE ----------------------
-
-// HandleDeleteWithCtx deletes a file from S3 using a cancellable context
-func HandleDeleteWithCtx(ctx context.Context, s3Client *s3.Client, bucketName string) fiber.Handler {
+// HandleSetTagsWithCtx replaces the extra tags of the components of a document, or of the
+// component named by compId, with the X-tag-* request headers using a cancellable context.
+// The tags written by the adapter itself are kept.
+func HandleSetTagsWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		updateMaxMemory()
 		start := time.Now()
 
-		docID := c.Query("docId")
+		docID := DocID(c)
 		if docID == "" {
 			logRequest(c, start, "ERROR=missing docId")
 			return c.Status(http.StatusBadRequest).SendString("docId required")
 		}
 
-		_, err := s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(docID),
-		})
+		extraTags, err := RequestTags(c, repo)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+
+		keys, err := tagTargets(ctx, repo, docID, c.Query("compId"))
+		for _, key := range keys {
+			if err = setExtraTags(ctx, repo, key, extraTags); err != nil {
+				break
+			}
+		}
+		if errors.Is(err, ErrInvalidTags) {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+		if errors.Is(err, ErrDocumentNotFound) || IsNotFound(err) {
+			logRequest(c, start, "ERROR=document not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", docID))
+		}
 		if err != nil {
 			select {
 			case <-ctx.Done():
 				logRequest(c, start, "CANCELLED")
-				return c.Status(fiber.StatusRequestTimeout).SendString("delete cancelled")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
 			default:
 				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
-				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("delete error: %v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("setTags error: %v", err))
 			}
 		}
 
-		logRequest(c, start, "DELETED")
-		return c.Status(http.StatusOK).SendString(fmt.Sprintf("DELETED %s", docID))
+		logRequest(c, start, fmt.Sprintf("SETTAGS components=%d tags=%d", len(keys), len(extraTags)))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("TAGS %s", docID))
 	}
 }
 
-// ---------------------- DOWNLOAD ----------------------
+// setExtraTags replaces the extra tags of one component
+func setExtraTags(ctx context.Context, repo *Repository, key string, extra map[string]string) error {
+	tags, err := GetObjectTags(ctx, repo, key)
+	if err != nil {
+		return err
+	}
+	if tags, err = WithExtraTags(tags, extra); err != nil {
+		return err
+	}
+	return PutObjectTags(ctx, repo, key, tags)
+}
+
+// tagTargets returns the keys of the components addressed by a tag command: the named
+// component, or all components of the document when compID is empty
+func tagTargets(ctx context.Context, repo *Repository, docID, compID string) ([]string, error) {
+	if compID != "" {
+		key, _, err := ResolveComponent(ctx, repo, docID, compID)
+		if err != nil && !IsNotFound(err) {
+			return nil, err
+		}
+		return []string{key}, nil
+	}
+
+	objects, err := ListComponents(ctx, repo, docID)
+	if err != nil {
+		return nil, err
+	}
+	if len(objects) == 0 {
+		return nil, ErrDocumentNotFound
+	}
+	keys := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		keys = append(keys, aws.ToString(obj.Key))
+	}
+	return keys, nil
+}
+
+// ---------------------- SEARCH ----------------------
 
-// HandleGetWithCtx downloads a file from S3 using a cancellable context
-func HandleGetWithCtx(ctx context.Context, s3Client *s3.Client, bucketName string) fiber.Handler {
+// HandleSearchWithCtx searches a component for a pattern using a cancellable context.
+// The component is streamed from S3 starting at fromOffset (up to toOffset) and scanned
+// chunk by chunk. The result lists the offsets of the hits in the ArchiveLink format.
+func HandleSearchWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		updateMaxMemory()
 		start := time.Now()
 
-		docID := c.Query("docId")
+		docID := DocID(c)
 		if docID == "" {
 			logRequest(c, start, "ERROR=missing docId")
 			return c.Status(http.StatusBadRequest).SendString("docId required")
 		}
 
-		// Read object metadata first
-		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(docID),
-		})
+		pattern := c.Query("pattern")
+		if pattern == "" {
+			logRequest(c, start, "ERROR=missing pattern")
+			return c.Status(http.StatusBadRequest).SendString("pattern required")
+		}
+		caseSensitive := c.Query("caseSensitive", "y") != "n"
+		maxHits, err := strconv.Atoi(c.Query("numResults", "0"))
+		if err != nil || maxHits < 0 {
+			logRequest(c, start, "ERROR=invalid numResults")
+			return c.Status(http.StatusBadRequest).SendString("invalid numResults")
+		}
+
+		key, head, err := ResolveComponent(ctx, repo, docID, c.Query("compId"))
 		if err != nil {
 			select {
 			case <-ctx.Done():
@@ -140,14 +1194,20 @@ func HandleGetWithCtx(ctx context.Context, s3Client *s3.Client, bucketName strin
 			}
 		}
 
-		filename := docID
-		if head.Metadata != nil && head.Metadata["filename"] != "" {
-			filename = head.Metadata["filename"]
+		byteRange, err := offsetRange(c.Query("fromOffset"), c.Query("toOffset"), aws.ToInt64(head.ContentLength))
+		if errors.Is(err, ErrRangeNotSatisfiable) {
+			logRequest(c, start, "SEARCH hits=0")
+			return c.Status(http.StatusOK).SendString(FormatSearchResult(nil))
+		}
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
 		}
 
-		out, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(docID),
+		out, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(repo.Bucket),
+			Key:    aws.String(key),
+			Range:  aws.String(byteRange.S3Range()),
 		})
 		if err != nil {
 			select {
@@ -161,43 +1221,66 @@ func HandleGetWithCtx(ctx context.Context, s3Client *s3.Client, bucketName strin
 		}
 		defer out.Body.Close()
 
-		c.Response().Header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
-		c.Status(http.StatusOK)
-
-		n, err := io.Copy(c.Response().BodyWriter(), out.Body)
+		hits, err := SearchStream(out.Body, byteRange.Start, []byte(pattern), caseSensitive, maxHits)
 		if err != nil {
 			select {
 			case <-ctx.Done():
 				logRequest(c, start, "CANCELLED")
-				return c.Status(fiber.StatusRequestTimeout).SendString("download cancelled")
+				return c.Status(fiber.StatusRequestTimeout).SendString("search cancelled")
 			default:
-				logRequest(c, start, fmt.Sprintf("ERROR copying body: %v", err))
-				return c.Status(http.StatusInternalServerError).SendString("internal error")
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("search error: %v", err))
 			}
 		}
 
-		logRequest(c, start, fmt.Sprintf("SERVED %s size=%d", filename, n))
-		return nil
+		logRequest(c, start, fmt.Sprintf("SEARCH hits=%d", len(hits)))
+		c.Set("Content-Type", fiber.MIMETextPlainCharsetUTF8)
+		return c.Status(http.StatusOK).SendString(FormatSearchResult(hits))
 	}
 }
 
-// ---------------------- INFO ----------------------
-
-// HandleInfoWithCtx provides metadata about an S3 object
-func HandleInfoWithCtx(ctx context.Context, s3Client *s3.Client, bucketName string) fiber.Handler {
+// HandleAttrSearchWithCtx evaluates an attribute search expression against the descriptor
+// component of a document using a cancellable context. The descriptor is streamed from S3
+// line by line and the offsets and lengths of matching entries are returned in the
+// ArchiveLink format.
+func HandleAttrSearchWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		updateMaxMemory()
 		start := time.Now()
 
-		docID := c.Query("docId")
+		docID := DocID(c)
 		if docID == "" {
 			logRequest(c, start, "ERROR=missing docId")
 			return c.Status(http.StatusBadRequest).SendString("docId required")
 		}
 
-		head, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
-			Bucket: aws.String(bucketName),
-			Key:    aws.String(docID),
+		expr, err := ParseAttrExpression(c.Query("pattern"))
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(err.Error())
+		}
+		caseSensitive := c.Query("caseSensitive", "y") != "n"
+
+		from, err := strconv.ParseInt(c.Query("fromOffset", "0"), 10, 64)
+		if err != nil {
+			logRequest(c, start, "ERROR=invalid fromOffset")
+			return c.Status(http.StatusBadRequest).SendString("invalid fromOffset")
+		}
+		to, err := strconv.ParseInt(c.Query("toOffset", "-1"), 10, 64)
+		if err != nil {
+			logRequest(c, start, "ERROR=invalid toOffset")
+			return c.Status(http.StatusBadRequest).SendString("invalid toOffset")
+		}
+		maxHits, err := strconv.Atoi(c.Query("numResults", "0"))
+		if err != nil || maxHits < 0 {
+			logRequest(c, start, "ERROR=invalid numResults")
+			return c.Status(http.StatusBadRequest).SendString("invalid numResults")
+		}
+
+		descrCompID := c.Query("descrCompId", DefaultDescrCompID)
+		out, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(repo.Bucket),
+			Key:    aws.String(repo.ComponentKey(docID, descrCompID)),
 		})
 		if err != nil {
 			select {
@@ -209,29 +1292,259 @@ func HandleInfoWithCtx(ctx context.Context, s3Client *s3.Client, bucketName stri
 				return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %v", err))
 			}
 		}
+		defer out.Body.Close()
 
-		c.Status(fiber.StatusOK).JSON(fiber.Map{
-			"docId":        docID,
-			"size":         head.ContentLength,
-			"lastModified": head.LastModified,
-			"contentType":  head.ContentType,
-			"etag":         head.ETag,
-		})
-		logRequest(c, start, "INFO")
-		return nil
+		hits, err := AttrSearchStream(out.Body, expr, caseSensitive, from, to, maxHits)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("search cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusUnprocessableEntity).SendString(fmt.Sprintf("attrSearch error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("ATTRSEARCH hits=%d", len(hits)))
+		c.Set("Content-Type", fiber.MIMETextPlainCharsetUTF8)
+		return c.Status(http.StatusOK).SendString(FormatAttrSearchResult(hits))
+	}
+}
+
+// ---------------------- CERTIFICATES ----------------------
+
+// maxCertificateSize bounds the certificate putCert reads into memory
+const maxCertificateSize = 64 << 10
+
+// HandlePutCertWithCtx registers the client certificate sent by an SAP system for an
+// authId using a cancellable context. New certificates are inactive until an admin
+// activates them. A registered certificate is only replaced by an admin or by a request
+// signed with it; a replacement signed by the owner stays active. putCert is not a signed
+// document command, so it checks the repository state itself.
+func HandlePutCertWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		authID := c.Query("authId")
+		if authID == "" {
+			logRequest(c, start, "ERROR=missing authId")
+			return c.Status(http.StatusBadRequest).SendString("authId required")
+		}
+
+		if status, err := checkRepositoryState(ctx, repo, "c"); err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(status).SendString(err.Error())
+		}
+
+		body := RequestBodyStream(c)
+		if IsMultipartForm(c) {
+			fileReader, _, err := ExtractFileStream(c)
+			if err != nil {
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("file read error: %v", err))
+			}
+			body = fileReader
+		}
+		data, err := readAtMost(body, maxCertificateSize)
+		if errors.Is(err, ErrBodyTooLarge) {
+			logRequest(c, start, "ERROR=certificate too large")
+			return c.Status(http.StatusRequestEntityTooLarge).SendString("certificate too large")
+		}
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("file read error: %v", err))
+		}
+
+		cert, err := ParseCertificate(data)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid certificate: %v", err))
+		}
+
+		// Only the owner, which signs with the active certificate, keeps it active
+		active := false
+		_, err = LoadCertificate(ctx, repo, authID)
+		switch {
+		case errors.Is(err, ErrCertNotFound):
+		case err != nil:
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("putCert error: %v", err))
+			}
+		case HasValidSecKey(c, repo):
+			active = true
+		case !IsAdmin(c):
+			logRequest(c, start, "ERROR=certificate already registered")
+			return c.Status(http.StatusForbidden).SendString(fmt.Sprintf("certificate for %s already registered", authID))
+		}
+
+		if err := StoreCertificate(ctx, repo, authID, data, active); err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("putCert error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("PUTCERT authId=%s subject=%s active=%t", authID, cert.Subject, active))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("CERTIFICATE %s stored", authID))
+	}
+}
+
+// HandleSetCertActiveWithCtx activates or deactivates a registered certificate using a
+// cancellable context. Requires the admin token.
+func HandleSetCertActiveWithCtx(ctx context.Context, repo *Repository, active bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		if !IsAdmin(c) {
+			logRequest(c, start, "ERROR=admin token required")
+			return c.Status(http.StatusForbidden).SendString("admin token required")
+		}
+
+		authID := c.Query("authId")
+		if authID == "" {
+			logRequest(c, start, "ERROR=missing authId")
+			return c.Status(http.StatusBadRequest).SendString("authId required")
+		}
+
+		err := SetCertificateActive(ctx, repo, authID, active)
+		if errors.Is(err, ErrCertNotFound) {
+			logRequest(c, start, "ERROR=certificate not found")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("not found: %s", authID))
+		}
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("certificate error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("CERT authId=%s active=%t", authID, active))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("CERTIFICATE %s active=%t", authID, active))
+	}
+}
+
+// ---------------------- ADMIN ----------------------
+
+// HandleAdminContRepWithCtx changes the state of a content repository (online, read-only,
+// locked, offline) using a cancellable context. Requires the admin token.
+func HandleAdminContRepWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		if !IsAdmin(c) {
+			logRequest(c, start, "ERROR=admin token required")
+			return c.Status(http.StatusForbidden).SendString("admin token required")
+		}
+
+		state := c.Query("state")
+		if !s3_adapter_config.IsValidRepoState(state) {
+			logRequest(c, start, fmt.Sprintf("ERROR=invalid state %q", state))
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("invalid state %q", state))
+		}
+
+		if err := StoreRepositoryState(ctx, repo, state); err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("adminContRep error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("ADMINCONTREP state=%s", state))
+		return c.Status(http.StatusOK).SendString(fmt.Sprintf("CONTREP %s %s", repo.Name, state))
+	}
+}
+
+// HandleMigrateDocIDsWithCtx moves components stored under a docId that does not match the
+// docIdCase policy of the repository to their normalised key and returns a JSON report.
+// With dryRun=y the report is produced without changing anything. Requires the admin token
+// and, unless it is a dry run, an online repository.
+func HandleMigrateDocIDsWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		updateMaxMemory()
+		start := time.Now()
+
+		if !IsAdmin(c) {
+			logRequest(c, start, "ERROR=admin token required")
+			return c.Status(http.StatusForbidden).SendString("admin token required")
+		}
+
+		// Moving objects is a write the repository state must allow, a dry run only reads
+		dryRun := c.Query("dryRun") == "y"
+		mode := "u"
+		if dryRun {
+			mode = "r"
+		}
+		if status, err := checkRepositoryState(ctx, repo, mode); err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(status).SendString(err.Error())
+		}
+
+		report, err := MigrateDocIDs(ctx, repo, dryRun)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("migrateDocIds error: %v", err))
+			}
+		}
+
+		logRequest(c, start, fmt.Sprintf("MIGRATEDOCIDS dryRun=%t migrated=%d conflicts=%d invalid=%d",
+			report.DryRun, len(report.Migrated), len(report.Conflicts), len(report.Invalid)))
+		return c.Status(http.StatusOK).JSON(report)
 	}
 }
 
 // ---------------------- LIST ----------------------
 
-// HandleListWithCtx lists objects in a bucket with context cancellation
-func HandleListWithCtx(ctx context.Context, s3Client *s3.Client, bucketName string) fiber.Handler {
+// ListEntry describes one component in a list response with details=y
+type ListEntry struct {
+	Key          string    `json:"key"`
+	DocID        string    `json:"docId"`
+	CompID       string    `json:"compId"`
+	Filename     string    `json:"filename,omitempty"`
+	ContentType  string    `json:"contentType"`
+	Size         int64     `json:"size"`
+	DateC        string    `json:"dateC,omitempty"`
+	TimeC        string    `json:"timeC,omitempty"`
+	DateM        string    `json:"dateM,omitempty"`
+	TimeM        string    `json:"timeM,omitempty"`
+	LastModified time.Time `json:"lastModified"`
+}
+
+// HandleListWithCtx lists the objects of a content repository with context cancellation.
+// With details=y the attributes of every component are read and returned as well.
+func HandleListWithCtx(ctx context.Context, repo *Repository) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		start := time.Now()
 		updateMaxMemory()
 
-		out, err := s3Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
-			Bucket: aws.String(bucketName),
+		out, err := repo.Client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket: aws.String(repo.Bucket),
+			Prefix: aws.String(repo.Prefix),
 		})
 		if err != nil {
 			select {
@@ -239,19 +1552,66 @@ func HandleListWithCtx(ctx context.Context, s3Client *s3.Client, bucketName stri
 				logRequest(c, start, "CANCELLED")
 				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
 			default:
-				log.Printf("ListObjectsV2 error for bucket %s: %v", bucketName, err)
+				log.Printf("ListObjectsV2 error for bucket %s: %v", repo.Bucket, err)
 				logRequest(c, start, fmt.Sprintf("LIST count=0 ERROR=%v", err))
 				return c.Status(fiber.StatusOK).JSON([]string{})
 			}
 		}
 
-		items := make([]string, 0, len(out.Contents))
+		if c.Query("details") != "y" {
+			items := make([]string, 0, len(out.Contents))
+			for _, obj := range out.Contents {
+				key := repo.RelKey(*obj.Key)
+				if IsReservedKey(key) {
+					continue
+				}
+				items = append(items, key)
+			}
+
+			logRequest(c, start, fmt.Sprintf("LIST count=%d", len(items)))
+			return c.Status(fiber.StatusOK).JSON(items)
+		}
+
+		entries := make([]ListEntry, 0, len(out.Contents))
 		for _, obj := range out.Contents {
-			items = append(items, *obj.Key)
+			key := repo.RelKey(*obj.Key)
+			if IsReservedKey(key) {
+				continue
+			}
+
+			meta, head, err := LoadComponentMeta(ctx, repo, *obj.Key)
+			if IsNotFound(err) {
+				continue // deleted since it was listed
+			}
+			if err != nil {
+				select {
+				case <-ctx.Done():
+					logRequest(c, start, "CANCELLED")
+					return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+				default:
+					logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+					return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("list error: %v", err))
+				}
+			}
+
+			docID, _, _ := strings.Cut(key, "/")
+			entries = append(entries, ListEntry{
+				Key:          key,
+				DocID:        docID,
+				CompID:       CompIDFromKey(docID, key),
+				Filename:     meta.Filename,
+				ContentType:  valueOr(aws.ToString(head.ContentType), DefaultContentType),
+				Size:         aws.ToInt64(head.ContentLength),
+				DateC:        meta.DateC,
+				TimeC:        meta.TimeC,
+				DateM:        meta.DateM,
+				TimeM:        meta.TimeM,
+				LastModified: aws.ToTime(head.LastModified),
+			})
 		}
 
-		logRequest(c, start, fmt.Sprintf("LIST count=%d", len(items)))
-		return c.Status(fiber.StatusOK).JSON(items)
+		logRequest(c, start, fmt.Sprintf("LIST details count=%d", len(entries)))
+		return c.Status(fiber.StatusOK).JSON(entries)
 	}
 }
 
@@ -268,8 +1628,8 @@ func HandleMem() fiber.Handler {
 	}
 }
 
-// HandleServerInfo returns runtime and memory info about the server
-func HandleServerInfo() fiber.Handler {
+// HandleDiagnostics returns runtime and memory statistics of the server
+func HandleDiagnostics() fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var m runtime.MemStats
 		runtime.ReadMemStats(&m)
@@ -282,7 +1642,70 @@ func HandleServerInfo() fiber.Handler {
 			"totalAlloc":   m.TotalAlloc,
 			"sys":          m.Sys,
 			"maxAlloc":     getMaxMemory(),
+			"uploaders":    uploaderSettings(),
 		})
 		return nil
 	}
 }
+
+// HandleServerInfo describes the server and its content repositories in the ArchiveLink
+// serverInfo format using a cancellable context. Only the requested repository is
+// described when contRep is set. The response is multipart/form-data, ASCII with
+// resultAs=ascii, or JSON when the client explicitly accepts application/json.
+func HandleServerInfo(ctx context.Context) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		var repos []*Repository
+		if repo := CurrentRepository(c); repo != nil {
+			repos = []*Repository{repo}
+		}
+
+		info, err := LoadServerInfo(ctx, repos)
+		if err != nil {
+			select {
+			case <-ctx.Done():
+				logRequest(c, start, "CANCELLED")
+				return c.Status(fiber.StatusRequestTimeout).SendString("request cancelled")
+			default:
+				logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+				return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("serverInfo error: %v", err))
+			}
+		}
+		info.PVersion = PVersion(c)
+
+		if strings.Contains(c.Get("Accept"), fiber.MIMEApplicationJSON) {
+			logRequest(c, start, fmt.Sprintf("SERVERINFO json repositories=%d", len(info.Repositories)))
+			return c.Status(fiber.StatusOK).JSON(info)
+		}
+
+		for name, value := range info.Headers() {
+			c.Set(name, value)
+		}
+
+		if c.Query("resultAs") == "ascii" {
+			c.Set("Content-Type", fiber.MIMETextPlainCharsetUTF8)
+			logRequest(c, start, fmt.Sprintf("SERVERINFO ascii repositories=%d", len(info.Repositories)))
+			return c.Status(fiber.StatusOK).SendString(info.ASCIIBody())
+		}
+
+		boundary := uuid.New().String()
+		body, err := info.MultipartBody(boundary)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(http.StatusInternalServerError).SendString(fmt.Sprintf("serverInfo error: %v", err))
+		}
+		c.Set("Content-Type", "multipart/form-data; boundary="+boundary)
+		logRequest(c, start, fmt.Sprintf("SERVERINFO repositories=%d", len(info.Repositories)))
+		return c.Status(fiber.StatusOK).Send(body)
+	}
+}
+
+// uploaderSettings returns the multipart uploader settings of every content repository
+func uploaderSettings() map[string]s3_adapter_config.UploaderConfig {
+	settings := make(map[string]s3_adapter_config.UploaderConfig)
+	for _, repo := range Repositories() {
+		settings[repo.Name] = *repo.Config.Uploader
+	}
+	return settings
+}
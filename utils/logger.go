@@ -3,27 +3,54 @@ package utils
 import (
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-// logRequest logs each incoming request with metadata and processing duration
-func logRequest(c *fiber.Ctx, start time.Time, extra string) {
+// requestLog holds the request fields written to the log, copied out of the fiber.Ctx so
+// they can still be logged after the handler has returned and the Ctx has been released
+type requestLog struct {
+	requestID string
+	method    string
+	path      string
+	funcName  string
+	ip        string
+}
+
+// newRequestLog copies the fields logged for a request. The values fiber returns point
+// into buffers that are reused once the Ctx is released, so each one is cloned.
+func newRequestLog(c *fiber.Ctx) requestLog {
 	requestID := c.Get("X-Request-ID")
 	if requestID == "" {
 		requestID = fmt.Sprintf("%d", time.Now().UnixNano())
 	}
+	return requestLog{
+		requestID: strings.Clone(requestID),
+		method:    strings.Clone(c.Method()),
+		path:      strings.Clone(c.Path()),
+		funcName:  strings.Clone(c.Query("funcName")),
+		ip:        strings.Clone(c.IP()),
+	}
+}
 
+// log logs the request with its processing duration
+func (r requestLog) log(start time.Time, extra string) {
 	duration := time.Since(start).Round(time.Millisecond)
 	log.Printf("[%s] req=%s %s %s func=%s ip=%s duration=%v %s",
 		time.Now().Format(time.RFC3339),
-		requestID,
-		c.Method(),
-		c.Path(),
-		c.Query("funcName"),
-		c.IP(),
+		r.requestID,
+		r.method,
+		r.path,
+		r.funcName,
+		r.ip,
 		duration,
 		extra,
 	)
 }
+
+// logRequest logs each incoming request with metadata and processing duration
+func logRequest(c *fiber.Ctx, start time.Time, extra string) {
+	newRequestLog(c).log(start, extra)
+}
@@ -0,0 +1,202 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// User metadata keys (x-amz-meta-*) holding the document attributes of a component.
+// S3 returns metadata keys in lower case, so they are stored that way.
+const (
+	metaContRep  = "contrep"
+	metaDocID    = "docid"
+	metaCompID   = "compid"
+	metaFilename = "filename"
+	metaDateC    = "datec"
+	metaTimeC    = "timec"
+	metaDateM    = "datem"
+	metaTimeM    = "timem"
+)
+
+// ComponentMeta holds the document attributes of a component. They are stored as S3
+// user metadata; only the attributes needed by bucket policies are also stored as tags.
+type ComponentMeta struct {
+	ContRep  string
+	DocID    string
+	CompID   string
+	Filename string
+	DateC    string
+	TimeC    string
+	DateM    string
+	TimeM    string
+}
+
+// NewComponentMeta returns the attributes of a newly created component
+func NewComponentMeta(contRep, docID, compID, filename string, now time.Time) ComponentMeta {
+	meta := ComponentMeta{
+		ContRep:  contRep,
+		DocID:    docID,
+		CompID:   compID,
+		Filename: filename,
+		DateC:    now.Format(DateLayout),
+		TimeC:    now.Format(TimeLayout),
+	}
+	meta.Touch(now)
+	return meta
+}
+
+// Touch sets the modification date and time of a component
+func (m *ComponentMeta) Touch(now time.Time) {
+	m.DateM = now.Format(DateLayout)
+	m.TimeM = now.Format(TimeLayout)
+}
+
+// Metadata encodes the attributes as S3 user metadata. Values must be US-ASCII in
+// HTTP headers, so non-ASCII filenames are stored as RFC 2047 encoded words.
+func (m ComponentMeta) Metadata() map[string]string {
+	md := map[string]string{
+		metaContRep: m.ContRep,
+		metaDocID:   m.DocID,
+		metaCompID:  m.CompID,
+		metaDateC:   m.DateC,
+		metaTimeC:   m.TimeC,
+		metaDateM:   m.DateM,
+		metaTimeM:   m.TimeM,
+	}
+	if m.Filename != "" {
+		md[metaFilename] = encodeMetaValue(m.Filename)
+	}
+	return md
+}
+
+// Tags returns the attributes stored as object tags so lifecycle and replication rules
+// can select the objects of a repository or document
+func (m ComponentMeta) Tags() map[string]string {
+	return map[string]string{
+		"contRep": m.ContRep,
+		"docId":   m.DocID,
+		"compId":  m.CompID,
+	}
+}
+
+// ParseComponentMeta decodes the attributes of a component from its S3 user metadata.
+// Components stored before attributes moved to metadata carry them only as tags;
+// legacyTags, when given, fills the attributes missing from the metadata.
+func ParseComponentMeta(md, legacyTags map[string]string) ComponentMeta {
+	get := func(key, tag string) string {
+		if v := md[key]; v != "" {
+			return v
+		}
+		return legacyTags[tag]
+	}
+	return ComponentMeta{
+		ContRep:  get(metaContRep, "contRep"),
+		DocID:    get(metaDocID, "docId"),
+		CompID:   get(metaCompID, "compId"),
+		Filename: decodeMetaValue(get(metaFilename, "filename")),
+		DateC:    get(metaDateC, "X-dateC"),
+		TimeC:    get(metaTimeC, "X-timeC"),
+		DateM:    get(metaDateM, "X-dateM"),
+		TimeM:    get(metaTimeM, "X-timeM"),
+	}
+}
+
+// HasDates reports whether the creation and modification attributes are all set
+func (m ComponentMeta) HasDates() bool {
+	return m.DateC != "" && m.TimeC != "" && m.DateM != "" && m.TimeM != ""
+}
+
+// encodeMetaValue returns a metadata value as US-ASCII, RFC 2047 encoding it if needed
+func encodeMetaValue(v string) string {
+	if isPlainASCII(v) {
+		return v
+	}
+	return mime.QEncoding.Encode("utf-8", v)
+}
+
+// decodeMetaValue decodes RFC 2047 encoded words in a metadata value
+func decodeMetaValue(v string) string {
+	if !strings.Contains(v, "=?") {
+		return v
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(v)
+	if err != nil {
+		return v
+	}
+	return decoded
+}
+
+// isPlainASCII reports whether s consists of printable US-ASCII characters only
+func isPlainASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// ContentDisposition returns a Content-Disposition header value for a filename. Non-ASCII
+// filenames are sent percent-encoded in filename* (RFC 6266) with an ASCII fallback.
+func ContentDisposition(disposition, filename string) string {
+	if isPlainASCII(filename) {
+		return fmt.Sprintf("%s; filename=%q", disposition, filename)
+	}
+
+	var fallback, encoded strings.Builder
+	for _, r := range filename {
+		if r < 0x20 || r > 0x7e {
+			fallback.WriteByte('_')
+		} else {
+			fallback.WriteRune(r)
+		}
+	}
+	for _, b := range []byte(filename) {
+		if isAttrChar(b) {
+			encoded.WriteByte(b)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+	return fmt.Sprintf("%s; filename=%q; filename*=UTF-8''%s", disposition, fallback.String(), encoded.String())
+}
+
+// isAttrChar reports whether b may appear unescaped in an RFC 5987 extended value
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// LoadComponentMeta reads the attributes of a component from S3 together with its metadata
+func LoadComponentMeta(ctx context.Context, repo *Repository, key string) (ComponentMeta, *s3.HeadObjectOutput, error) {
+	head, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return ComponentMeta{}, nil, err
+	}
+	return componentMeta(ctx, repo, key, head.Metadata), head, nil
+}
+
+// componentMeta decodes the attributes of a component from its user metadata, reading its
+// tags only for components stored before attributes moved to metadata
+func componentMeta(ctx context.Context, repo *Repository, key string, md map[string]string) ComponentMeta {
+	meta := ParseComponentMeta(md, nil)
+	if meta.HasDates() {
+		return meta
+	}
+	if tags, err := GetObjectTags(ctx, repo, key); err == nil {
+		meta = ParseComponentMeta(md, tags)
+	}
+	return meta
+}
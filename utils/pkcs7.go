@@ -0,0 +1,175 @@
+package utils
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// pkcs7ContentInfo is the outer PKCS#7 envelope
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData is the PKCS#7 SignedData structure (RFC 2315, section 9.1)
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+// pkcs7SignerInfo describes one signature of a SignedData structure
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     pkcs7IssuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7IssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type pkcs7Attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type dsaSignature struct {
+	R, S *big.Int
+}
+
+// VerifyPKCS7 checks a detached PKCS#7 signature over content against a certificate.
+// DSA, RSA and ECDSA keys are supported with those of the SHA-1 and SHA-256 digests that
+// are listed in digests.
+func VerifyPKCS7(signature, content []byte, cert *x509.Certificate, digests []crypto.Hash) error {
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(signature, &info); err != nil {
+		return fmt.Errorf("pkcs7: %w", err)
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return errors.New("pkcs7: content is not SignedData")
+	}
+
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &sd); err != nil {
+		return fmt.Errorf("pkcs7: %w", err)
+	}
+	if len(sd.SignerInfos) == 0 {
+		return errors.New("pkcs7: no signer")
+	}
+
+	for _, signer := range sd.SignerInfos {
+		if signer.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber) != 0 ||
+			!bytes.Equal(signer.IssuerAndSerialNumber.Issuer.FullBytes, cert.RawIssuer) {
+			continue
+		}
+		return verifySignerInfo(signer, content, cert, digests)
+	}
+	return errors.New("pkcs7: signature was not made with the registered certificate")
+}
+
+// verifySignerInfo checks one signer against the content
+func verifySignerInfo(signer pkcs7SignerInfo, content []byte, cert *x509.Certificate, digests []crypto.Hash) error {
+	hash, err := pkcs7Hash(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(digests, hash) {
+		return fmt.Errorf("pkcs7: digest algorithm %v not allowed", hash)
+	}
+
+	h := hash.New()
+	h.Write(content)
+	digest := h.Sum(nil)
+
+	if len(signer.AuthenticatedAttributes.FullBytes) > 0 {
+		// The signature covers the DER encoding of the attributes as a SET OF
+		signed := append([]byte{}, signer.AuthenticatedAttributes.FullBytes...)
+		signed[0] = 0x31
+
+		var attrs []pkcs7Attribute
+		if _, err := asn1.UnmarshalWithParams(signed, &attrs, "set"); err != nil {
+			return fmt.Errorf("pkcs7: attributes: %w", err)
+		}
+
+		var messageDigest []byte
+		for _, attr := range attrs {
+			if attr.Type.Equal(oidMessageDigest) {
+				if _, err := asn1.Unmarshal(attr.Values.Bytes, &messageDigest); err != nil {
+					return fmt.Errorf("pkcs7: message digest: %w", err)
+				}
+			}
+		}
+		if !bytes.Equal(messageDigest, digest) {
+			return errors.New("pkcs7: message digest mismatch")
+		}
+
+		h = hash.New()
+		h.Write(signed)
+		digest = h.Sum(nil)
+	}
+
+	switch pub := cert.PublicKey.(type) {
+	case *dsa.PublicKey:
+		var sig dsaSignature
+		if _, err := asn1.Unmarshal(signer.EncryptedDigest, &sig); err != nil {
+			return fmt.Errorf("pkcs7: dsa signature: %w", err)
+		}
+		if n := (pub.Q.BitLen() + 7) / 8; len(digest) > n {
+			digest = digest[:n]
+		}
+		if !dsa.Verify(pub, digest, sig.R, sig.S) {
+			return errors.New("pkcs7: invalid signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, hash, digest, signer.EncryptedDigest); err != nil {
+			return errors.New("pkcs7: invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, signer.EncryptedDigest) {
+			return errors.New("pkcs7: invalid signature")
+		}
+	default:
+		return fmt.Errorf("pkcs7: unsupported public key type %T", pub)
+	}
+	return nil
+}
+
+// pkcs7Hash maps a digest algorithm identifier to a hash function
+func pkcs7Hash(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	default:
+		return 0, fmt.Errorf("pkcs7: unsupported digest algorithm %v", oid)
+	}
+}
@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"crypto"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// commandsByMethod lists the content server commands accepted for each HTTP method,
+// in the order they are matched against the query string. The last entry of POST
+// and DELETE is also the default when no command is named. The middleware checks and
+// the dispatch in main.go both go through Command, so they always agree on the command.
+var commandsByMethod = map[string][]string{
+	fiber.MethodGet:    {"serverInfo", "get", "docGet", "info", "list", "search", "attrSearch", "getTags", "uploadStatus"},
+	fiber.MethodPost:   {"mCreate", "initUpload", "create"},
+	fiber.MethodPut:    {"putCert", "activateCert", "deactivateCert", "adminContRep", "migrateDocIds", "setTags", "uploadChunk", "completeUpload", "create", "update", "append"},
+	fiber.MethodDelete: {"abortUpload", "delete"},
+}
+
+// commandAccessModes maps commands to the ArchiveLink access mode a signed URL must grant
+var commandAccessModes = map[string]string{
+	"get":            "r",
+	"docGet":         "r",
+	"info":           "r",
+	"list":           "r",
+	"search":         "r",
+	"attrSearch":     "r",
+	"getTags":        "r",
+	"create":         "c",
+	"mCreate":        "c",
+	"initUpload":     "c",
+	"uploadChunk":    "c",
+	"uploadStatus":   "c",
+	"completeUpload": "c",
+	"abortUpload":    "c",
+	"update":         "u",
+	"append":         "u",
+	"setTags":        "u",
+	"delete":         "d",
+}
+
+// documentCommands are the commands that address a document and need a docId
+var documentCommands = map[string]bool{
+	"get":        true,
+	"docGet":     true,
+	"info":       true,
+	"search":     true,
+	"attrSearch": true,
+	"getTags":    true,
+	"create":     true,
+	"initUpload": true,
+	"setTags":    true,
+	"update":     true,
+	"append":     true,
+	"delete":     true,
+}
+
+// commandMinPVersions lists commands that are not available in every protocol version
+var commandMinPVersions = map[string]string{
+	"mCreate":    "0046",
+	"attrSearch": "0046",
+}
+
+// fieldMinPVersions lists attributes of info responses that older protocol versions do not
+// define; they are left out of the headers and ascii lines sent to those versions
+var fieldMinPVersions = map[string]string{
+	"docStatus":  "0046",
+	"compStatus": "0046",
+}
+
+// signatureDigests lists the digest algorithms a secKey may use in each protocol version
+var signatureDigests = map[string][]crypto.Hash{
+	"0045": {crypto.SHA1},
+	"0046": {crypto.SHA1},
+	"0047": {crypto.SHA1, crypto.SHA256},
+}
+
+// Command returns the content server command of a request, or an empty string if the
+// request does not name a known command
+func Command(c *fiber.Ctx) string {
+	commands := commandsByMethod[c.Method()]
+	q := c.Queries()
+	for _, cmd := range commands {
+		if _, ok := q[cmd]; ok {
+			return cmd
+		}
+	}
+
+	switch c.Method() {
+	case fiber.MethodPost, fiber.MethodDelete:
+		return commands[len(commands)-1]
+	}
+	return ""
+}
+
+// IsDocumentCommand reports whether a command addresses a document and needs a docId
+func IsDocumentCommand(cmd string) bool {
+	return documentCommands[cmd]
+}
+
+// PVersionOffers reports whether a protocol version defines an attribute of info responses
+func PVersionOffers(pVersion, field string) bool {
+	minVersion, ok := fieldMinPVersions[field]
+	return !ok || pVersion >= minVersion
+}
+
+// SignatureDigests returns the digest algorithms a secKey may use in a protocol version
+func SignatureDigests(pVersion string) []crypto.Hash {
+	return signatureDigests[pVersion]
+}
+
+// PVersion returns the protocol version negotiated for a request
+func PVersion(c *fiber.Ctx) string {
+	if pVersion, ok := c.Locals("pVersion").(string); ok {
+		return pVersion
+	}
+	return DefaultPVersion
+}
+
+// NegotiatePVersion returns a middleware that validates the pVersion parameter, rejects
+// commands the requested protocol version does not offer and echoes X-pVersion
+func NegotiatePVersion() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		pVersion := c.Query("pVersion")
+		if pVersion == "" {
+			pVersion = DefaultPVersion
+		}
+		if !slices.Contains(SupportedPVersions, pVersion) {
+			logRequest(c, start, fmt.Sprintf("ERROR=unsupported pVersion %s", pVersion))
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("unsupported pVersion %s", pVersion))
+		}
+
+		c.Locals("pVersion", pVersion)
+		c.Set("X-pVersion", pVersion)
+
+		cmd := Command(c)
+		if minVersion, ok := commandMinPVersions[cmd]; ok && pVersion < minVersion {
+			logRequest(c, start, fmt.Sprintf("ERROR=%s requires pVersion %s", cmd, minVersion))
+			return c.Status(http.StatusBadRequest).SendString(fmt.Sprintf("%s requires pVersion %s or later", cmd, minVersion))
+		}
+		return c.Next()
+	}
+}
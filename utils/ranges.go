@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// ErrRangeNotSatisfiable is returned when a requested range lies outside the object
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ByteRange is an inclusive byte range of an object
+type ByteRange struct {
+	Start, End int64
+}
+
+// S3Range returns the range in the format of the S3 GetObject Range parameter
+func (r ByteRange) S3Range() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// ContentRange returns the value of the Content-Range response header
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.End, size)
+}
+
+// Length returns the number of bytes in the range
+func (r ByteRange) Length() int64 {
+	return r.End - r.Start + 1
+}
+
+// RequestedRange returns the byte range requested with the ArchiveLink fromOffset/toOffset
+// parameters or the HTTP Range header, in that order of precedence. It returns nil when the
+// whole object is requested or the Range header is invalid, ErrRangeNotSatisfiable when the
+// range lies outside the object and an error for invalid offsets.
+func RequestedRange(c *fiber.Ctx, size int64) (*ByteRange, error) {
+	from, to := c.Query("fromOffset"), c.Query("toOffset")
+	if from != "" || to != "" {
+		return offsetRange(from, to, size)
+	}
+	if header := c.Get(fiber.HeaderRange); header != "" {
+		return parseRangeHeader(header, size)
+	}
+	return nil, nil
+}
+
+// offsetRange converts ArchiveLink offsets (toOffset inclusive, -1 or empty for the end)
+func offsetRange(from, to string, size int64) (*ByteRange, error) {
+	r := ByteRange{Start: 0, End: size - 1}
+
+	if from != "" {
+		n, err := strconv.ParseInt(from, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid fromOffset %q", from)
+		}
+		r.Start = n
+	}
+	if to != "" && to != "-1" {
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid toOffset %q", to)
+		}
+		r.End = min(n, size-1)
+	}
+
+	if r.Start >= size || r.Start > r.End {
+		return nil, ErrRangeNotSatisfiable
+	}
+	return &r, nil
+}
+
+// parseRangeHeader parses a single-range HTTP Range header. Multiple ranges are not
+// supported and, like a header with invalid syntax, are answered with the whole object,
+// as RFC 9110 (section 14.2) allows.
+func parseRangeHeader(header string, size int64) (*ByteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	var r ByteRange
+	switch {
+	case first == "":
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseUint(last, 10, 63)
+		if err != nil {
+			return nil, nil
+		}
+		if n == 0 {
+			return nil, ErrRangeNotSatisfiable
+		}
+		r = ByteRange{Start: max(size-int64(n), 0), End: size - 1}
+	default:
+		start, err := strconv.ParseUint(first, 10, 63)
+		if err != nil {
+			return nil, nil
+		}
+		r = ByteRange{Start: int64(start), End: size - 1}
+		if last != "" {
+			end, err := strconv.ParseUint(last, 10, 63)
+			if err != nil || end < start {
+				return nil, nil
+			}
+			r.End = min(int64(end), size-1)
+		}
+	}
+
+	if r.Start >= size {
+		return nil, ErrRangeNotSatisfiable
+	}
+	return &r, nil
+}
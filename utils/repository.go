@@ -0,0 +1,191 @@
+package utils
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gofiber/fiber/v2"
+)
+
+var (
+	appConfig     *s3_adapter_config.Config // configuration shared by all handlers
+	appConfigOnce sync.Once                 // guards loading appConfig
+)
+
+// GetAppConfig returns the server configuration, loading it on first use
+func GetAppConfig() *s3_adapter_config.Config {
+	appConfigOnce.Do(func() {
+		cfg, err := s3_adapter_config.GetConfig()
+		if err != nil {
+			log.Fatalf("Failed to load config: %v", err)
+		}
+		appConfig = cfg
+	})
+	return appConfig
+}
+
+// Repository is a content repository (contRep) resolved to the S3 location of its documents
+type Repository struct {
+	Name     string            // contRep as sent by clients
+	Bucket   string            // bucket holding the documents
+	Prefix   string            // key prefix of all objects, empty or ending with "/"
+	Client   *s3.Client        // client of the endpoint serving the bucket
+	Uploader *manager.Uploader // multipart uploader shared by all uploads to the repository
+	Config   s3_adapter_config.ContentRepository
+}
+
+// Key returns the S3 key of an object given its key relative to the repository
+func (r *Repository) Key(rel string) string {
+	return r.Prefix + rel
+}
+
+// RelKey returns the key of an S3 object relative to the repository
+func (r *Repository) RelKey(key string) string {
+	return strings.TrimPrefix(key, r.Prefix)
+}
+
+// ComponentKey returns the S3 key of a component of the repository
+func (r *Repository) ComponentKey(docID, compID string) string {
+	return r.Key(ComponentKey(docID, compID))
+}
+
+// LegacyKey returns the S3 key of a document stored before documents had components,
+// which is read as its data component
+func (r *Repository) LegacyKey(docID string) string {
+	return r.Key(docID)
+}
+
+// DocumentPrefix returns the S3 key prefix shared by all components of a document
+func (r *Repository) DocumentPrefix(docID string) string {
+	return r.Key(DocumentPrefix(docID))
+}
+
+// CompIDFromKey extracts the component name from an S3 key of the given document
+func (r *Repository) CompIDFromKey(docID, key string) string {
+	return CompIDFromKey(docID, r.RelKey(key))
+}
+
+// repositories is the registry of configured content repositories keyed by contRep
+var repositories map[string]*Repository
+
+// InitRepositories builds the content repository registry from the configuration.
+// Repositories on the default endpoint use defaultClient; repositories with their own
+// endpoint or credentials share one client per distinct endpoint. Every repository gets
+// an uploader tuned by its uploader settings, and repositories creating with conditional
+// writes are probed for backend support.
+func InitRepositories(defaultClient *s3.Client) {
+	cfg := GetAppConfig()
+	clients := map[s3_adapter_config.S3Config]*s3.Client{cfg.S3: defaultClient}
+
+	repositories = make(map[string]*Repository, len(cfg.ContentRepositories))
+	for name := range cfg.ContentRepositories {
+		settings, _ := cfg.ContentRepository(name)
+
+		client, ok := clients[*settings.S3]
+		if !ok {
+			client = NewS3Client(*settings.S3)
+			clients[*settings.S3] = client
+		}
+
+		if err := settings.Uploader.Validate(); err != nil {
+			log.Fatalf("Content repository %s: %v", name, err)
+		}
+
+		repositories[name] = &Repository{
+			Name:     name,
+			Bucket:   settings.Bucket,
+			Prefix:   settings.Prefix,
+			Client:   client,
+			Uploader: NewS3Uploader(client, *settings.Uploader),
+			Config:   settings,
+		}
+		if settings.CreateMode == s3_adapter_config.CreateModeConditional {
+			probeAtStartup(repositories[name])
+		}
+		log.Printf("Content repository %s: bucket=%s prefix=%q endpoint=%s partSize=%d concurrency=%d",
+			name, settings.Bucket, settings.Prefix, settings.S3.Url, settings.Uploader.PartSize, settings.Uploader.Concurrency)
+	}
+}
+
+// startupProbeTimeout bounds the conditional write probe of a repository at startup
+const startupProbeTimeout = 10 * time.Second
+
+// probeAtStartup probes a repository for conditional writes unless it does not take writes.
+// A repository skipped here is switched over by its first create instead.
+func probeAtStartup(repo *Repository) {
+	ctx, cancel := context.WithTimeout(context.Background(), startupProbeTimeout)
+	defer cancel()
+
+	// The probe is a write, which locked, read-only and offline repositories do not take
+	if _, err := checkRepositoryState(ctx, repo, "c"); err != nil {
+		log.Printf("Content repository %s: conditional write probe skipped: %v", repo.Name, err)
+		return
+	}
+	if err := ProbeConditionalWrites(ctx, repo); err != nil {
+		log.Printf("Content repository %s: conditional write probe failed: %v", repo.Name, err)
+	}
+}
+
+// LookupRepository returns the configured content repository with the given contRep
+func LookupRepository(contRep string) (*Repository, bool) {
+	repo, ok := repositories[contRep]
+	return repo, ok
+}
+
+// Repositories returns all configured content repositories ordered by contRep
+func Repositories() []*Repository {
+	repos := make([]*Repository, 0, len(repositories))
+	for _, repo := range repositories {
+		repos = append(repos, repo)
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].Name < repos[j].Name })
+	return repos
+}
+
+// ResolveRepository returns a middleware that resolves the contRep of a request to a
+// configured content repository, rejecting unknown repositories with 404
+func ResolveRepository() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		contRep := c.Query("contRep")
+		if contRep == "" {
+			return c.Next()
+		}
+
+		repo, ok := LookupRepository(contRep)
+		if !ok {
+			logRequest(c, start, "ERROR=unknown contRep")
+			return c.Status(http.StatusNotFound).SendString(fmt.Sprintf("unknown content repository %s", contRep))
+		}
+		c.Locals("repo", repo)
+		return c.Next()
+	}
+}
+
+// CurrentRepository returns the content repository resolved for a request, or nil
+// when the request names none
+func CurrentRepository(c *fiber.Ctx) *Repository {
+	repo, _ := c.Locals("repo").(*Repository)
+	return repo
+}
+
+// IsAdmin reports whether a request carries the configured admin token.
+// No request is an admin request when no token is configured.
+func IsAdmin(c *fiber.Ctx) bool {
+	token := GetAppConfig().Server.AdminToken
+	if token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Get("X-Admin-Token")), []byte(token)) == 1
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/gofiber/fiber/v2"
+)
+
+// AdminPrefix is the reserved key prefix for administrative data of a content repository
+const AdminPrefix = "_admin/"
+
+// repoStateKey is the repository-relative key holding the state set with adminContRep
+const repoStateKey = AdminPrefix + "state"
+
+// repoStateCacheTTL is how long a repository state is reused before it is read from S3
+// again, bounding how long prefork processes may disagree after a change
+const repoStateCacheTTL = 5 * time.Second
+
+type cachedRepoState struct {
+	state  string
+	loaded time.Time
+}
+
+// repoStateCache holds recently loaded repository states keyed by contRep
+var repoStateCache sync.Map
+
+// LoadRepositoryState returns the current state of a content repository: the state set
+// with adminContRep if any, otherwise the configured state
+func LoadRepositoryState(ctx context.Context, repo *Repository) (string, error) {
+	if v, ok := repoStateCache.Load(repo.Name); ok {
+		cached := v.(cachedRepoState)
+		if time.Since(cached.loaded) < repoStateCacheTTL {
+			return cached.state, nil
+		}
+	}
+
+	state := repo.Config.State
+	out, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(repo.Key(repoStateKey)),
+	})
+	switch {
+	case err == nil:
+		data, readErr := io.ReadAll(out.Body)
+		out.Body.Close()
+		if readErr != nil {
+			return "", readErr
+		}
+		if stored := strings.TrimSpace(string(data)); s3_adapter_config.IsValidRepoState(stored) {
+			state = stored
+		}
+	case !IsNotFound(err):
+		return "", err
+	}
+
+	repoStateCache.Store(repo.Name, cachedRepoState{state: state, loaded: time.Now()})
+	return state, nil
+}
+
+// StoreRepositoryState persists the state of a content repository
+func StoreRepositoryState(ctx context.Context, repo *Repository, state string) error {
+	_, err := repo.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(repo.Bucket),
+		Key:         aws.String(repo.Key(repoStateKey)),
+		Body:        strings.NewReader(state),
+		ContentType: aws.String(fiber.MIMETextPlain),
+	})
+	repoStateCache.Delete(repo.Name)
+	return err
+}
+
+// RepoStatus maps a repository state to the contRepStatus reported by serverInfo
+func RepoStatus(state string) string {
+	if state == s3_adapter_config.RepoStateOnline {
+		return ContRepStatusRunning
+	}
+	return state
+}
+
+// EnforceRepositoryState returns a middleware that rejects document commands the state
+// of the content repository does not allow: writes are refused with 403 unless the
+// repository is online, and every document command with 503 while it is offline.
+// Server and admin commands pass; migrateDocIds checks the state itself.
+func EnforceRepositoryState() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		repo := CurrentRepository(c)
+		mode := RequiredAccessMode(c)
+		if repo == nil || mode == "" {
+			return c.Next()
+		}
+
+		if status, err := checkRepositoryState(c.Locals("ctx").(context.Context), repo, mode); err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=%v", err))
+			return c.Status(status).SendString(err.Error())
+		}
+		return c.Next()
+	}
+}
+
+// checkRepositoryState returns the HTTP status and error to reply with when the state of a
+// content repository does not allow access in the given mode (r for reads, else writes)
+func checkRepositoryState(ctx context.Context, repo *Repository, mode string) (int, error) {
+	state, err := LoadRepositoryState(ctx, repo)
+	if err != nil {
+		return http.StatusInternalServerError, fmt.Errorf("repository state error: %w", err)
+	}
+
+	switch {
+	case state == s3_adapter_config.RepoStateOffline:
+		return http.StatusServiceUnavailable, fmt.Errorf("content repository %s is offline", repo.Name)
+	case state != s3_adapter_config.RepoStateOnline && mode != "r":
+		return http.StatusForbidden, fmt.Errorf("content repository %s is %s", repo.Name, state)
+	}
+	return 0, nil
+}
@@ -0,0 +1,463 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// UploadPrefix is the reserved key prefix under which resumable upload sessions are stored
+const UploadPrefix = "_uploads/"
+
+// uploadCleanupInterval is how often expired uploads are removed
+const uploadCleanupInterval = time.Hour
+
+// chunkSpoolDir is the directory chunks are spooled to before they are sent to S3
+var chunkSpoolDir = filepath.Join(os.TempDir(), "s3-adapter-chunks")
+
+// Errors of the resumable upload commands
+var (
+	ErrUploadNotFound = errors.New("upload not found")
+	ErrUploadExpired  = errors.New("upload expired")
+	ErrInvalidChunk   = fmt.Errorf("chunk must be a number between 1 and %d", s3_adapter_config.MaxUploadParts)
+	ErrNoChunks       = errors.New("no chunks uploaded")
+	ErrChunkTooSmall  = errors.New("every chunk but the last must be at least 5 MiB")
+	ErrMissingChunk   = errors.New("chunks must be numbered from 1 without gaps")
+)
+
+// UploadSession is a resumable upload in progress. It wraps an S3 multipart upload of the
+// component; the attributes of the component are fixed when the upload is initiated.
+type UploadSession struct {
+	UploadID    string    `json:"uploadId"`
+	S3UploadID  string    `json:"s3UploadId"`
+	DocID       string    `json:"docId"`
+	CompID      string    `json:"compId"`
+	Key         string    `json:"key"`
+	ContentType string    `json:"contentType"`
+	Created     time.Time `json:"created"`
+	Expires     time.Time `json:"expires"`
+}
+
+// Expired reports whether the upload can no longer be continued at the given time
+func (s *UploadSession) Expired(now time.Time) bool {
+	return now.After(s.Expires)
+}
+
+// UploadedChunk describes a chunk that has arrived in S3
+type UploadedChunk struct {
+	Chunk int32  `json:"chunk"`
+	Size  int64  `json:"size"`
+	ETag  string `json:"etag"`
+}
+
+// uploadSessionKey returns the repository-relative key of an upload session
+func uploadSessionKey(uploadID string) string {
+	return UploadPrefix + uploadID
+}
+
+// DeclaredContentType returns the Content-Type to store for content that cannot be sniffed:
+// the declared media type, or DefaultContentType, with the ArchiveLink charset and version
+// parameters applied
+func DeclaredContentType(declared, charset, version string) string {
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil || mediaType == "" {
+		mediaType, params = DefaultContentType, map[string]string{}
+	}
+	if charset != "" {
+		params["charset"] = charset
+	}
+	if version != "" {
+		params["version"] = version
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// InitiateUpload starts a resumable upload of a component. Like create it refuses an
+// existing component unless the repository's create mode is overwrite.
+func InitiateUpload(ctx context.Context, repo *Repository, docID, compID string, attrs ObjectAttributes) (*UploadSession, error) {
+	key := repo.ComponentKey(docID, compID)
+	if repo.Config.CreateMode != s3_adapter_config.CreateModeOverwrite {
+		if err := checkKeyFree(ctx, repo, key); err != nil {
+			return nil, err
+		}
+	}
+
+	created, err := repo.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(repo.Bucket),
+		Key:          aws.String(key),
+		ContentType:  aws.String(attrs.ContentType),
+		Metadata:     attrs.Metadata,
+		Tagging:      aws.String(EncodeTags(attrs.Tags)),
+		StorageClass: types.StorageClass(repo.Config.StorageClass),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	session := &UploadSession{
+		UploadID:    uuid.New().String(),
+		S3UploadID:  aws.ToString(created.UploadId),
+		DocID:       docID,
+		CompID:      compID,
+		Key:         key,
+		ContentType: attrs.ContentType,
+		Created:     time.Now(),
+	}
+	session.Expires = session.Created.Add(repo.Config.UploadExpiry)
+	data, err := json.Marshal(session)
+	if err == nil {
+		_, err = repo.Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(repo.Bucket),
+			Key:         aws.String(repo.Key(uploadSessionKey(session.UploadID))),
+			Body:        bytes.NewReader(data),
+			ContentType: aws.String(fiber.MIMEApplicationJSON),
+		})
+	}
+	if err != nil {
+		abortMultipartUpload(repo, key, session.S3UploadID)
+		return nil, err
+	}
+	return session, nil
+}
+
+// LoadUploadSession reads a resumable upload session of a repository
+func LoadUploadSession(ctx context.Context, repo *Repository, uploadID string) (*UploadSession, error) {
+	if uuid.Validate(uploadID) != nil {
+		return nil, ErrUploadNotFound
+	}
+	out, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(repo.Key(uploadSessionKey(uploadID))),
+	})
+	if IsNotFound(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+
+	var session UploadSession
+	if err := json.NewDecoder(out.Body).Decode(&session); err != nil {
+		return nil, fmt.Errorf("upload session %s: %w", uploadID, err)
+	}
+	if session.Expires.IsZero() {
+		session.Expires = session.Created.Add(repo.Config.UploadExpiry)
+	}
+	return &session, nil
+}
+
+// UploadChunk stores a numbered chunk of a resumable upload. Uploading a chunk again
+// replaces it, so interrupted chunks can simply be retried. The chunk is spooled to a
+// temporary file so it can be sent to S3 with a known length without being held in memory.
+func UploadChunk(ctx context.Context, repo *Repository, session *UploadSession, chunk int32, body io.Reader) (*UploadedChunk, error) {
+	if chunk < 1 || chunk > s3_adapter_config.MaxUploadParts {
+		return nil, ErrInvalidChunk
+	}
+	if session.Expired(time.Now()) {
+		return nil, ErrUploadExpired
+	}
+
+	if err := os.MkdirAll(chunkSpoolDir, 0o700); err != nil {
+		return nil, err
+	}
+	spool, err := os.CreateTemp(chunkSpoolDir, "chunk-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	out, err := repo.Client.UploadPart(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(repo.Bucket),
+		Key:           aws.String(session.Key),
+		UploadId:      aws.String(session.S3UploadID),
+		PartNumber:    aws.Int32(chunk),
+		Body:          spool,
+		ContentLength: aws.Int64(size),
+	})
+	if isNoSuchUpload(err) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &UploadedChunk{Chunk: chunk, Size: size, ETag: aws.ToString(out.ETag)}, nil
+}
+
+// ListUploadedChunks returns the chunks of a resumable upload that have arrived, in order
+func ListUploadedChunks(ctx context.Context, repo *Repository, session *UploadSession) ([]UploadedChunk, error) {
+	chunks := []UploadedChunk{}
+	paginator := s3.NewListPartsPaginator(repo.Client, &s3.ListPartsInput{
+		Bucket:   aws.String(repo.Bucket),
+		Key:      aws.String(session.Key),
+		UploadId: aws.String(session.S3UploadID),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if isNoSuchUpload(err) {
+			return nil, ErrUploadNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range page.Parts {
+			chunks = append(chunks, UploadedChunk{
+				Chunk: aws.ToInt32(part.PartNumber),
+				Size:  aws.ToInt64(part.Size),
+				ETag:  aws.ToString(part.ETag),
+			})
+		}
+	}
+	return chunks, nil
+}
+
+// CompleteUpload assembles the uploaded chunks, in chunk order, into the component and
+// ends the session. It returns the size of the component. The chunks must be numbered 1 to
+// N without gaps and, if expected is not 0, N must equal expected, so a lost chunk fails the
+// upload instead of leaving a component with a hole. S3 requires every chunk but the last
+// to be at least 5 MiB.
+func CompleteUpload(ctx context.Context, repo *Repository, session *UploadSession, expected int32) (int64, error) {
+	if session.Expired(time.Now()) {
+		return 0, ErrUploadExpired
+	}
+	chunks, err := ListUploadedChunks(ctx, repo, session)
+	if err != nil {
+		return 0, err
+	}
+	if len(chunks) == 0 {
+		return 0, ErrNoChunks
+	}
+	for i, chunk := range chunks {
+		if chunk.Chunk != int32(i+1) {
+			return 0, fmt.Errorf("%w: chunk %d is missing", ErrMissingChunk, i+1)
+		}
+	}
+	if expected != 0 && int32(len(chunks)) != expected {
+		return 0, fmt.Errorf("%w: %d of %d chunks uploaded", ErrMissingChunk, len(chunks), expected)
+	}
+
+	var size int64
+	parts := make([]types.CompletedPart, 0, len(chunks))
+	for _, chunk := range chunks {
+		parts = append(parts, types.CompletedPart{ETag: aws.String(chunk.ETag), PartNumber: aws.Int32(chunk.Chunk)})
+		size += chunk.Size
+	}
+
+	if err := completeMultipartUpload(ctx, repo, session, parts); err != nil {
+		return 0, err
+	}
+	deleteUploadSession(ctx, repo, session)
+	return size, nil
+}
+
+// completeMultipartUpload completes the S3 multipart upload of a session without replacing
+// an existing object, according to the create mode of the content repository
+func completeMultipartUpload(ctx context.Context, repo *Repository, session *UploadSession, parts []types.CompletedPart) error {
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(repo.Bucket),
+		Key:             aws.String(session.Key),
+		UploadId:        aws.String(session.S3UploadID),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	}
+
+	switch repo.Config.CreateMode {
+	case s3_adapter_config.CreateModeOverwrite:
+	case s3_adapter_config.CreateModeConditional:
+		if _, unsupported := noConditionalWrites.Load(repo.Name); !unsupported {
+			input.IfNoneMatch = aws.String("*")
+			break
+		}
+		fallthrough
+	default:
+		if err := checkKeyFree(ctx, repo, session.Key); err != nil {
+			return err
+		}
+	}
+
+	_, err := repo.Client.CompleteMultipartUpload(ctx, input)
+	switch {
+	case err == nil:
+		return nil
+	case isPreconditionFailed(err):
+		return ErrAlreadyExists
+	case isNoSuchUpload(err):
+		return ErrUploadNotFound
+	case s3ErrorCode(err) == "EntityTooSmall":
+		return ErrChunkTooSmall
+	case input.IfNoneMatch != nil && isNotImplemented(err):
+		// The parts are kept by S3, so the completion can be retried with an existence check
+		markNoConditionalWrites(repo)
+		return completeMultipartUpload(ctx, repo, session, parts)
+	default:
+		return err
+	}
+}
+
+// AbortUpload discards a resumable upload and the chunks uploaded so far
+func AbortUpload(ctx context.Context, repo *Repository, session *UploadSession) error {
+	_, err := repo.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(repo.Bucket),
+		Key:      aws.String(session.Key),
+		UploadId: aws.String(session.S3UploadID),
+	})
+	if err != nil && !isNoSuchUpload(err) {
+		return err
+	}
+	deleteUploadSession(ctx, repo, session)
+	return nil
+}
+
+// deleteUploadSession removes the stored session of a finished upload. A leftover session
+// only makes later commands for it fail with ErrUploadNotFound, so errors are logged.
+func deleteUploadSession(ctx context.Context, repo *Repository, session *UploadSession) {
+	_, err := repo.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(repo.Key(uploadSessionKey(session.UploadID))),
+	})
+	if err != nil {
+		log.Printf("Failed to delete upload session %s of %s: %v", session.UploadID, repo.Name, err)
+	}
+}
+
+// RunUploadCleanup removes expired uploads of every content repository, staged update
+// objects and chunk spool files left behind, once at startup and then every uploadCleanupInterval until ctx is done
+func RunUploadCleanup(ctx context.Context) {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+	for {
+		var maxExpiry time.Duration
+		for _, repo := range Repositories() {
+			maxExpiry = max(maxExpiry, repo.Config.UploadExpiry)
+
+			// Like any other write, removing uploads needs a writable repository
+			if _, err := checkRepositoryState(ctx, repo, "d"); err != nil {
+				continue
+			}
+			removed, err := RemoveExpiredUploads(ctx, repo, time.Now())
+			if err != nil {
+				log.Printf("Content repository %s: removing expired uploads failed: %v", repo.Name, err)
+			}
+			if removed > 0 {
+				log.Printf("Content repository %s: removed %d expired uploads", repo.Name, removed)
+			}
+
+			removed, err = RemoveStaleStaging(ctx, repo, time.Now().Add(-repo.Config.UploadExpiry))
+			if err != nil {
+				log.Printf("Content repository %s: removing stale staged updates failed: %v", repo.Name, err)
+			}
+			if removed > 0 {
+				log.Printf("Content repository %s: removed %d stale staged update objects", repo.Name, removed)
+			}
+		}
+		removeStaleSpools(maxExpiry)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RemoveExpiredUploads aborts the resumable uploads of a repository that have expired at
+// the given time, together with their sessions, and returns how many were removed
+func RemoveExpiredUploads(ctx context.Context, repo *Repository, now time.Time) (int, error) {
+	removed := 0
+	paginator := s3.NewListObjectsV2Paginator(repo.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(repo.Bucket),
+		Prefix: aws.String(repo.Key(UploadPrefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return removed, err
+		}
+
+		for _, obj := range page.Contents {
+			uploadID := strings.TrimPrefix(repo.RelKey(aws.ToString(obj.Key)), UploadPrefix)
+			session, err := LoadUploadSession(ctx, repo, uploadID)
+			if errors.Is(err, ErrUploadNotFound) {
+				continue // completed or aborted since it was listed
+			}
+			if err != nil {
+				log.Printf("Content repository %s: %v", repo.Name, err)
+				continue
+			}
+			if !session.Expired(now) {
+				continue
+			}
+			if err := AbortUpload(ctx, repo, session); err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeStaleSpools removes chunk spool files older than maxAge. A chunk request removes its
+// spool file itself, so these are only left behind by a process that stopped during one.
+func removeStaleSpools(maxAge time.Duration) {
+	entries, err := os.ReadDir(chunkSpoolDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err == nil && time.Since(info.ModTime()) > maxAge {
+			os.Remove(filepath.Join(chunkSpoolDir, entry.Name()))
+		}
+	}
+}
+
+// abortMultipartUpload aborts an S3 multipart upload that could not be registered
+func abortMultipartUpload(repo *Repository, key, uploadID string) {
+	_, _ = repo.Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(repo.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+}
+
+// isNoSuchUpload reports whether an S3 error means the multipart upload no longer exists
+func isNoSuchUpload(err error) bool {
+	var noSuchUpload *types.NoSuchUpload
+	if errors.As(err, &noSuchUpload) {
+		return true
+	}
+	return s3ErrorCode(err) == "NoSuchUpload"
+}
+
+// s3ErrorCode returns the error code of an S3 API error, or an empty string
+func s3ErrorCode(err error) string {
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) {
+		return apiErr.ErrorCode()
+	}
+	return ""
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// uploadSessionBackend emulates an S3 bucket holding upload sessions. It lists, serves and
+// deletes session objects and records the multipart uploads aborted.
+type uploadSessionBackend struct {
+	mu       sync.Mutex
+	sessions map[string][]byte
+	aborted  []string
+}
+
+func (b *uploadSessionBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"), "bucket/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		w.Header().Set("Content-Type", "application/xml")
+		fmt.Fprint(w, `<ListBucketResult><Name>bucket</Name><IsTruncated>false</IsTruncated>`)
+		for k, data := range b.sessions {
+			fmt.Fprintf(w, `<Contents><Key>%s</Key><Size>%d</Size></Contents>`, k, len(data))
+		}
+		fmt.Fprint(w, `</ListBucketResult>`)
+	case r.Method == http.MethodGet:
+		data, ok := b.sessions[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete && r.URL.Query().Has("uploadId"):
+		b.aborted = append(b.aborted, r.URL.Query().Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodDelete:
+		delete(b.sessions, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+// store saves a session as InitiateUpload would
+func (b *uploadSessionBackend) store(t *testing.T, session UploadSession) {
+	t.Helper()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		t.Fatalf("Encoding session failed: %v", err)
+	}
+	b.sessions[uploadSessionKey(session.UploadID)] = data
+}
+
+func TestRemoveExpiredUploads(t *testing.T) {
+	backend := &uploadSessionBackend{sessions: map[string][]byte{}}
+	repo := newTestRepository(t, "uploads", backend)
+	repo.Config.UploadExpiry = time.Hour
+
+	now := time.Now()
+	expired := UploadSession{UploadID: "0b6e2f3c-5d4a-4c1e-9f8b-7a6d5c4b3a21", S3UploadID: "expired", Key: "DOC1/data", Created: now.Add(-2 * time.Hour)}
+	expired.Expires = expired.Created.Add(time.Hour)
+	open := UploadSession{UploadID: "1c7f3a4d-6e5b-4d2f-8a9c-8b7e6d5c4b32", S3UploadID: "open", Key: "DOC2/data", Created: now}
+	open.Expires = open.Created.Add(time.Hour)
+	// Sessions stored before they had an expiry expire uploadExpiry after their creation
+	legacy := UploadSession{UploadID: "2d8a4b5e-7f6c-4e3a-9b0d-9c8f7e6d5c43", S3UploadID: "legacy", Key: "DOC3/data", Created: now.Add(-2 * time.Hour)}
+	for _, session := range []UploadSession{expired, open, legacy} {
+		backend.store(t, session)
+	}
+
+	removed, err := RemoveExpiredUploads(context.Background(), repo, now)
+	if err != nil {
+		t.Fatalf("RemoveExpiredUploads failed: %v", err)
+	}
+	slices.Sort(backend.aborted)
+	if removed != 2 || !slices.Equal(backend.aborted, []string{"expired", "legacy"}) {
+		t.Fatalf("Expected the expired and legacy uploads to be aborted, removed %d, aborted %v", removed, backend.aborted)
+	}
+	if _, ok := backend.sessions[uploadSessionKey(open.UploadID)]; !ok || len(backend.sessions) != 1 {
+		t.Fatalf("Expected only the open session to remain, got %d sessions", len(backend.sessions))
+	}
+
+	// ---- An expired session takes no more chunks and cannot be completed ----
+	if _, err := UploadChunk(context.Background(), repo, &expired, 1, strings.NewReader("chunk")); err != ErrUploadExpired {
+		t.Fatalf("Expected ErrUploadExpired for a chunk, got %v", err)
+	}
+	if _, err := CompleteUpload(context.Background(), repo, &expired, 0); err != ErrUploadExpired {
+		t.Fatalf("Expected ErrUploadExpired on completion, got %v", err)
+	}
+}
@@ -1,12 +1,15 @@
 package utils
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strings"
+	"net/url"
+	"sync"
 
 	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -14,7 +17,7 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/gofiber/fiber/v2"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 // CreateS3Client initializes an S3 client using configuration
@@ -23,26 +26,30 @@ func CreateS3Client() *s3.Client {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	return NewS3Client(cfg.S3)
+}
 
+// NewS3Client initializes an S3 client for an endpoint and its credentials
+func NewS3Client(endpoint s3_adapter_config.S3Config) *s3.Client {
 	httpClient := &http.Client{
 		Transport: &http.Transport{
-			MaxConnsPerHost: cfg.S3.MaxConnections,
+			MaxConnsPerHost: endpoint.MaxConnections,
 		},
 	}
 
 	// AWS SDK Config
 	s3Cfg, err := config.LoadDefaultConfig(
 		context.Background(),
-		config.WithRegion(cfg.S3.Region),
+		config.WithRegion(endpoint.Region),
 		config.WithCredentialsProvider(
-			credentials.NewStaticCredentialsProvider(cfg.S3.AccessKey, cfg.S3.SecretKey, ""),
+			credentials.NewStaticCredentialsProvider(endpoint.AccessKey, endpoint.SecretKey, ""),
 		),
 		config.WithEndpointResolverWithOptions(
 			aws.EndpointResolverWithOptionsFunc(
 				func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 					return aws.Endpoint{
-						URL:               cfg.S3.Url,
-						SigningRegion:     cfg.S3.Region,
+						URL:               endpoint.Url,
+						SigningRegion:     endpoint.Region,
 						HostnameImmutable: true,
 					}, nil
 				},
@@ -57,59 +64,298 @@ func CreateS3Client() *s3.Client {
 	return s3.NewFromConfig(s3Cfg)
 }
 
-// EncodeTags converts a key-value map into a query string for S3 object tagging
-func EncodeTags(tags map[string]string) string {
-	var sb strings.Builder
-	i := 0
-	for k, v := range tags {
-		if i > 0 {
-			sb.WriteByte('&')
+// ObjectAttributes are the attributes written to S3 together with the content of a component
+type ObjectAttributes struct {
+	ContentType string
+	Metadata    map[string]string // user metadata (x-amz-meta-*)
+	Tags        map[string]string
+}
+
+// ErrAlreadyExists is returned when a create targets an object that already exists
+var ErrAlreadyExists = errors.New("document already exists")
+
+// noConditionalWrites remembers repositories whose backend rejected If-None-Match writes
+var noConditionalWrites sync.Map
+
+// conditionalWriteProbeKey is the repository-relative key written to find out whether the
+// backend of a repository supports conditional writes
+const conditionalWriteProbeKey = AdminPrefix + "conditional-write-probe"
+
+// markNoConditionalWrites makes creates in a repository fall back to HeadObject checks
+func markNoConditionalWrites(repo *Repository) {
+	if _, known := noConditionalWrites.LoadOrStore(repo.Name, true); !known {
+		log.Printf("Bucket %s does not support conditional writes, falling back to HeadObject", repo.Bucket)
+	}
+}
+
+// ProbeConditionalWrites finds out whether the backend of a repository supports If-None-Match
+// writes by writing an empty object under the admin prefix. Streamed create bodies cannot be
+// rewound, so a create that finds out the hard way cannot be retried.
+func ProbeConditionalWrites(ctx context.Context, repo *Repository) error {
+	_, err := repo.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(repo.Bucket),
+		Key:         aws.String(repo.Key(conditionalWriteProbeKey)),
+		Body:        bytes.NewReader(nil),
+		IfNoneMatch: aws.String("*"),
+	})
+	switch {
+	case err == nil, isPreconditionFailed(err):
+		return nil
+	case isNotImplemented(err):
+		markNoConditionalWrites(repo)
+		return nil
+	default:
+		return err
+	}
+}
+
+// isPreconditionFailed reports whether an S3 error means a conditional write lost against an existing object
+func isPreconditionFailed(err error) bool {
+	var re interface{ HTTPStatusCode() int }
+	if errors.As(err, &re) {
+		code := re.HTTPStatusCode()
+		return code == http.StatusPreconditionFailed || code == http.StatusConflict
+	}
+	return false
+}
+
+// isNotImplemented reports whether an S3 error means the backend does not support a request feature
+func isNotImplemented(err error) bool {
+	var apiErr interface{ ErrorCode() string }
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotImplemented" {
+		return true
+	}
+	var re interface{ HTTPStatusCode() int }
+	return errors.As(err, &re) && re.HTTPStatusCode() == http.StatusNotImplemented
+}
+
+// CreateFileInS3Stream uploads a file stream to S3 without replacing an existing object,
+// according to the create mode of the content repository. It returns ErrAlreadyExists
+// when the key is taken.
+func CreateFileInS3Stream(ctx context.Context, repo *Repository, uploader *manager.Uploader, key string, body io.Reader, attrs ObjectAttributes) error {
+	switch repo.Config.CreateMode {
+	case s3_adapter_config.CreateModeOverwrite:
+		return UploadFileToS3Stream(ctx, repo, uploader, key, body, attrs)
+	case s3_adapter_config.CreateModeHead:
+		return headCheckedUpload(ctx, repo, uploader, key, body, attrs)
+	}
+
+	if _, unsupported := noConditionalWrites.Load(repo.Name); unsupported {
+		return headCheckedUpload(ctx, repo, uploader, key, body, attrs)
+	}
+
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(repo.Bucket),
+		Key:          aws.String(key),
+		Body:         body,
+		ContentType:  aws.String(attrs.ContentType),
+		Metadata:     attrs.Metadata,
+		Tagging:      aws.String(EncodeTags(attrs.Tags)),
+		StorageClass: types.StorageClass(repo.Config.StorageClass),
+		IfNoneMatch:  aws.String("*"),
+	})
+	switch {
+	case err == nil:
+		return nil
+	case isPreconditionFailed(err):
+		return ErrAlreadyExists
+	case isNotImplemented(err):
+		// Later creates use the existence check; this one is retried only if the body
+		// can be rewound, which streamed request bodies cannot
+		markNoConditionalWrites(repo)
+		seeker, ok := body.(io.Seeker)
+		if !ok {
+			return err
+		}
+		if _, seekErr := seeker.Seek(0, io.SeekStart); seekErr != nil {
+			return err
 		}
-		sb.WriteString(k)
-		sb.WriteByte('=')
-		sb.WriteString(v)
-		i++
+		return headCheckedUpload(ctx, repo, uploader, key, body, attrs)
+	default:
+		return err
+	}
+}
+
+// headCheckedUpload uploads a file stream to S3 after checking that the key is free
+func headCheckedUpload(ctx context.Context, repo *Repository, uploader *manager.Uploader, key string, body io.Reader, attrs ObjectAttributes) error {
+	if err := checkKeyFree(ctx, repo, key); err != nil {
+		return err
 	}
-	return sb.String()
+	return UploadFileToS3Stream(ctx, repo, uploader, key, body, attrs)
+}
+
+// checkKeyFree returns ErrAlreadyExists when an object exists under key
+func checkKeyFree(ctx context.Context, repo *Repository, key string) error {
+	_, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err == nil {
+		return ErrAlreadyExists
+	}
+	if !IsNotFound(err) {
+		return err
+	}
+	return nil
 }
 
 // UploadFileToS3Stream uploads a file stream to S3
-func UploadFileToS3Stream(ctx context.Context, uploader *manager.Uploader, bucketName, key string, body io.Reader, tags map[string]string) error {
+func UploadFileToS3Stream(ctx context.Context, repo *Repository, uploader *manager.Uploader, key string, body io.Reader, attrs ObjectAttributes) error {
 	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
-		Bucket:  aws.String(bucketName),
-		Key:     aws.String(key),
-		Body:    body,
-		Tagging: aws.String(EncodeTags(tags)),
+		Bucket:       aws.String(repo.Bucket),
+		Key:          aws.String(key),
+		Body:         body,
+		ContentType:  aws.String(attrs.ContentType),
+		Metadata:     attrs.Metadata,
+		Tagging:      aws.String(EncodeTags(attrs.Tags)),
+		StorageClass: types.StorageClass(repo.Config.StorageClass),
 	})
 	return err
 }
 
-// CreateS3Uploader creates a high-level S3 uploader
-func CreateS3Uploader(client *s3.Client) *manager.Uploader {
-	return manager.NewUploader(client)
-}
+// minPartSize is the smallest size S3 accepts for a multipart upload part other than the last one
+const minPartSize = 5 * 1024 * 1024
+
+// maxCopyPartSize is the largest range S3 accepts for a single UploadPartCopy call
+const maxCopyPartSize = 5 * 1024 * 1024 * 1024
 
-// ExtractFileStream extracts the first file stream from a multipart form
-func ExtractFileStream(c *fiber.Ctx) (io.Reader, string, error) {
-	form, err := c.MultipartForm()
+// ErrComponentChanged is returned when a component changes while data is appended to it
+var ErrComponentChanged = errors.New("component changed during append")
+
+// AppendToS3Object appends a stream to an existing S3 object, replacing its attributes.
+// The object is rewritten with a multipart upload: a large object is copied server-side,
+// a small one, which is below the S3 minimum part size, is read and sent again in front
+// of the new data. The new data is uploaded in parts of the repository's uploader part
+// size. The upload only completes if the object still has the ETag it had at the start,
+// so a concurrent append fails with ErrComponentChanged instead of being lost.
+func AppendToS3Object(ctx context.Context, repo *Repository, key string, data io.Reader, attrs ObjectAttributes) error {
+	head, err := repo.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
 	if err != nil {
-		return nil, "", fmt.Errorf("multipart reader: %w", err)
+		return err
 	}
+	size := aws.ToInt64(head.ContentLength)
 
-	for _, files := range form.File {
-		if len(files) == 0 {
-			continue
+	created, err := repo.Client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:       aws.String(repo.Bucket),
+		Key:          aws.String(key),
+		ContentType:  aws.String(attrs.ContentType),
+		Metadata:     attrs.Metadata,
+		Tagging:      aws.String(EncodeTags(attrs.Tags)),
+		StorageClass: types.StorageClass(repo.Config.StorageClass),
+	})
+	if err != nil {
+		return err
+	}
+
+	parts, err := appendParts(ctx, repo, key, created.UploadId, size, head.ETag, data)
+	if err == nil {
+		input := &s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(repo.Bucket),
+			Key:             aws.String(key),
+			UploadId:        created.UploadId,
+			MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+		}
+		// Without conditional writes only the copied parts are checked against the ETag
+		if _, unsupported := noConditionalWrites.Load(repo.Name); !unsupported {
+			input.IfMatch = head.ETag
 		}
+		_, err = repo.Client.CompleteMultipartUpload(ctx, input)
+	}
+	if err != nil {
+		_, _ = repo.Client.AbortMultipartUpload(context.Background(), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(repo.Bucket),
+			Key:      aws.String(key),
+			UploadId: created.UploadId,
+		})
+		if isPreconditionFailed(err) {
+			return ErrComponentChanged
+		}
+		return err
+	}
+	return nil
+}
+
+// appendParts adds the existing object with the given size and ETag to the upload, followed
+// by the new data
+func appendParts(ctx context.Context, repo *Repository, key string, uploadID *string, size int64, etag *string, data io.Reader) ([]types.CompletedPart, error) {
+	var parts []types.CompletedPart
+	partNumber := int32(1)
 
-		file := files[0]
-		f, err := file.Open()
+	if size < minPartSize {
+		// A copied part this small could not be followed by others, so send it again
+		existing, err := repo.Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(repo.Bucket),
+			Key:     aws.String(key),
+			IfMatch: etag,
+		})
 		if err != nil {
-			return nil, "", fmt.Errorf("open file: %w", err)
+			return nil, err
+		}
+		defer existing.Body.Close()
+		data = io.MultiReader(existing.Body, data)
+	} else {
+		// Split the existing object into equal ranges so no copied part is smaller than minPartSize
+		count := (size + maxCopyPartSize - 1) / maxCopyPartSize
+		rangeSize := (size + count - 1) / count
+		for offset := int64(0); offset < size; offset += rangeSize {
+			end := min(offset+rangeSize, size) - 1
+			out, err := repo.Client.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:            aws.String(repo.Bucket),
+				Key:               aws.String(key),
+				UploadId:          uploadID,
+				PartNumber:        aws.Int32(partNumber),
+				CopySource:        aws.String(url.PathEscape(repo.Bucket + "/" + key)),
+				CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", offset, end)),
+				CopySourceIfMatch: etag,
+			})
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, types.CompletedPart{ETag: out.CopyPartResult.ETag, PartNumber: aws.Int32(partNumber)})
+			partNumber++
 		}
+	}
 
-		// IMPORTANT: do not close f here, Fiber will handle closing
-		return f, file.Filename, nil
+	buf := make([]byte, repo.Config.Uploader.PartSize)
+	for {
+		n, readErr := io.ReadFull(data, buf)
+		// An upload needs at least one part, even when an empty object gets no data
+		if n > 0 || len(parts) == 0 {
+			out, err := repo.Client.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:     aws.String(repo.Bucket),
+				Key:        aws.String(key),
+				UploadId:   uploadID,
+				PartNumber: aws.Int32(partNumber),
+				Body:       bytes.NewReader(buf[:n]),
+			})
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNumber)})
+			partNumber++
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			return parts, nil
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
 	}
+}
 
-	return nil, "", fmt.Errorf("no file part found")
+// NewS3Uploader creates a high-level S3 uploader tuned by the given settings. Uploaders are
+// safe for concurrent use; each repository shares one between all its uploads.
+func NewS3Uploader(client *s3.Client, settings s3_adapter_config.UploaderConfig) *manager.Uploader {
+	return manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = settings.PartSize
+		u.Concurrency = settings.Concurrency
+		u.LeavePartsOnError = aws.ToBool(settings.LeavePartsOnError)
+		u.MaxUploadParts = settings.MaxUploadParts
+		if settings.BufferPoolSize > 0 {
+			u.BufferProvider = manager.NewBufferedReadSeekerWriteToPool(settings.BufferPoolSize)
+		}
+	})
 }
@@ -0,0 +1,174 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+)
+
+// noConditionalBackend emulates an S3 backend without If-None-Match support that holds no objects
+type noConditionalBackend struct {
+	mu       sync.Mutex
+	requests []string
+}
+
+func (b *noConditionalBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	io.Copy(io.Discard, r.Body)
+
+	request := r.Method
+	if r.Header.Get("If-None-Match") != "" {
+		request += " If-None-Match"
+	}
+	b.mu.Lock()
+	b.requests = append(b.requests, request)
+	b.mu.Unlock()
+
+	switch {
+	case r.Header.Get("If-None-Match") != "":
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotImplemented)
+		io.WriteString(w, `<Error><Code>NotImplemented</Code><Message>If-None-Match not supported</Message></Error>`)
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.Header().Set("ETag", `"etag"`)
+	}
+}
+
+// taken returns the requests received since the last call
+func (b *noConditionalBackend) taken() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests := b.requests
+	b.requests = nil
+	return requests
+}
+
+func newTestRepository(t *testing.T, name string, backend http.Handler) *Repository {
+	t.Helper()
+
+	// The emulated backend is plain HTTP, keep a CA bundle of the environment out of it
+	t.Setenv("AWS_CA_BUNDLE", "")
+
+	server := httptest.NewServer(backend)
+	t.Cleanup(server.Close)
+
+	client := NewS3Client(s3_adapter_config.S3Config{Url: server.URL, Region: "us-east-1", AccessKey: "key", SecretKey: "secret"})
+	return &Repository{
+		Name:     name,
+		Bucket:   "bucket",
+		Client:   client,
+		Uploader: manager.NewUploader(client),
+		Config:   s3_adapter_config.ContentRepository{CreateMode: s3_adapter_config.CreateModeConditional},
+	}
+}
+
+// streamedBody returns a body that cannot be rewound, like a streamed request body
+func streamedBody(content string) io.Reader {
+	return io.MultiReader(strings.NewReader(content))
+}
+
+func TestCreateFallsBackAfterProbe(t *testing.T) {
+	backend := &noConditionalBackend{}
+	repo := newTestRepository(t, "probed", backend)
+
+	if err := ProbeConditionalWrites(context.Background(), repo); err != nil {
+		t.Fatalf("Probe failed: %v", err)
+	}
+	backend.taken()
+
+	if err := CreateFileInS3Stream(context.Background(), repo, repo.Uploader, "DOC/data", streamedBody("content"), ObjectAttributes{}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if requests := backend.taken(); strings.Join(requests, ",") != "HEAD,PUT" {
+		t.Fatalf("Expected a HeadObject check and a plain upload, got %v", requests)
+	}
+}
+
+func TestCreateFallsBackAfterNotImplemented(t *testing.T) {
+	backend := &noConditionalBackend{}
+	repo := newTestRepository(t, "unprobed", backend)
+
+	// The first streamed create cannot be retried, but it must switch the repository over
+	if err := CreateFileInS3Stream(context.Background(), repo, repo.Uploader, "DOC/data", streamedBody("content"), ObjectAttributes{}); err == nil {
+		t.Fatalf("Expected the conditional write to fail")
+	}
+	backend.taken()
+
+	if err := CreateFileInS3Stream(context.Background(), repo, repo.Uploader, "DOC/data", streamedBody("content"), ObjectAttributes{}); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if requests := backend.taken(); strings.Join(requests, ",") != "HEAD,PUT" {
+		t.Fatalf("Expected a HeadObject check and a plain upload, got %v", requests)
+	}
+}
+
+// appendBackend emulates an S3 backend holding one small object whose ETag changes before
+// an append completes
+type appendBackend struct {
+	mu        sync.Mutex
+	partSizes []int
+	ifMatch   []string
+	aborted   bool
+}
+
+func (b *appendBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	query := r.URL.Query()
+	w.Header().Set("Content-Type", "application/xml")
+	switch {
+	case r.Method == http.MethodHead:
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "10")
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		io.WriteString(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>DOC/data</Key><UploadId>append</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodGet:
+		b.ifMatch = append(b.ifMatch, "GET "+r.Header.Get("If-Match"))
+		io.WriteString(w, "0123456789")
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		b.partSizes = append(b.partSizes, len(body))
+		w.Header().Set("ETag", `"part"`)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		b.ifMatch = append(b.ifMatch, "COMPLETE "+r.Header.Get("If-Match"))
+		w.WriteHeader(http.StatusPreconditionFailed)
+		io.WriteString(w, `<Error><Code>PreconditionFailed</Code><Message>At least one of the pre-conditions you specified did not hold</Message></Error>`)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		b.aborted = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestAppendDetectsConcurrentChange(t *testing.T) {
+	backend := &appendBackend{}
+	repo := newTestRepository(t, "append", backend)
+	repo.Config.Uploader = &s3_adapter_config.UploaderConfig{PartSize: 6 << 20}
+
+	data := strings.NewReader(strings.Repeat("A", 7<<20))
+	err := AppendToS3Object(context.Background(), repo, "DOC/data", data, ObjectAttributes{ContentType: "text/plain"})
+	if !errors.Is(err, ErrComponentChanged) {
+		t.Fatalf("Expected ErrComponentChanged, got %v", err)
+	}
+	if !backend.aborted {
+		t.Fatalf("Expected the multipart upload to be aborted")
+	}
+	if strings.Join(backend.ifMatch, ",") != `GET "v1",COMPLETE "v1"` {
+		t.Fatalf("Expected the read and the completion to be conditional on the ETag, got %v", backend.ifMatch)
+	}
+	// The existing 10 bytes are sent in front of the new data, in parts of the configured size
+	if len(backend.partSizes) != 2 || backend.partSizes[0] != 6<<20 || backend.partSizes[1] != 1<<20+10 {
+		t.Fatalf("Unexpected part sizes %v", backend.partSizes)
+	}
+}
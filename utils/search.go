@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// searchChunkSize is the amount of data read from S3 per scan step
+const searchChunkSize = 64 * 1024
+
+// MaxSearchHits caps the number of offsets returned by a single search
+const MaxSearchHits = 10000
+
+// SearchStream scans r for pattern and returns the absolute offsets of the matches,
+// where base is the offset of the first byte of r. Only a chunk of the stream plus
+// the pattern length is held in memory. Case-insensitive matching folds ASCII letters
+// so offsets stay byte-accurate. maxHits <= 0 means MaxSearchHits.
+func SearchStream(r io.Reader, base int64, pattern []byte, caseSensitive bool, maxHits int) ([]int64, error) {
+	if maxHits <= 0 || maxHits > MaxSearchHits {
+		maxHits = MaxSearchHits
+	}
+	if !caseSensitive {
+		pattern = foldASCII(bytes.Clone(pattern))
+	}
+
+	var hits []int64
+	chunk := make([]byte, searchChunkSize)
+	buf := make([]byte, 0, searchChunkSize+len(pattern))
+	offset := base // absolute offset of buf[0]
+
+	for {
+		n, err := r.Read(chunk)
+		if n > 0 {
+			data := chunk[:n]
+			if !caseSensitive {
+				foldASCII(data)
+			}
+			buf = append(buf, data...)
+
+			for from := 0; ; {
+				i := bytes.Index(buf[from:], pattern)
+				if i < 0 {
+					break
+				}
+				hits = append(hits, offset+int64(from+i))
+				if len(hits) >= maxHits {
+					return hits, nil
+				}
+				from += i + 1
+			}
+
+			// Keep the tail that could start a match continuing in the next chunk
+			keep := min(len(pattern)-1, len(buf))
+			offset += int64(len(buf) - keep)
+			buf = append(buf[:0], buf[len(buf)-keep:]...)
+		}
+		if err == io.EOF {
+			return hits, nil
+		}
+		if err != nil {
+			return hits, err
+		}
+	}
+}
+
+// FormatSearchResult encodes hits in the ArchiveLink search result format:
+// the number of hits followed by the offsets, each terminated by a semicolon
+func FormatSearchResult(hits []int64) string {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(hits)))
+	sb.WriteByte(';')
+	for _, hit := range hits {
+		sb.WriteString(strconv.FormatInt(hit, 10))
+		sb.WriteByte(';')
+	}
+	return sb.String()
+}
+
+// foldASCII lower-cases ASCII letters in place
+func foldASCII(b []byte) []byte {
+	for i, ch := range b {
+		if 'A' <= ch && ch <= 'Z' {
+			b[i] = ch + ('a' - 'A')
+		}
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// expirationLayout is the format of the expiration parameter of signed URLs (UTC)
+const expirationLayout = "20060102150405"
+
+// RequiredAccessMode returns the ArchiveLink access mode (r, c, u, d) needed by a
+// content server request, or an empty string for commands that are not signed
+func RequiredAccessMode(c *fiber.Ctx) string {
+	return commandAccessModes[Command(c)]
+}
+
+// SignedMessage returns the data covered by secKey: the values of all URL parameters
+// except secKey, concatenated in the order they appear in the URL
+func SignedMessage(c *fiber.Ctx) []byte {
+	var msg []byte
+	c.Context().QueryArgs().VisitAll(func(key, value []byte) {
+		if string(key) != "secKey" {
+			msg = append(msg, value...)
+		}
+	})
+	return msg
+}
+
+// VerifySecKey returns a middleware that validates SAP signed URLs. Requests without
+// secKey pass unless the content repository requires signatures.
+func VerifySecKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+
+		mode := RequiredAccessMode(c)
+		if mode == "" {
+			return c.Next()
+		}
+
+		repo := CurrentRepository(c)
+		secKey := c.Query("secKey")
+		if secKey == "" {
+			if repo != nil && repo.Config.RequireSignature {
+				logRequest(c, start, "ERROR=missing secKey")
+				return c.Status(http.StatusUnauthorized).SendString("secKey required")
+			}
+			return c.Next()
+		}
+
+		status, err := verifySecKey(c, repo, secKey, mode)
+		if err != nil {
+			logRequest(c, start, fmt.Sprintf("ERROR=secKey %v", err))
+			return c.Status(status).SendString(fmt.Sprintf("secKey rejected: %v", err))
+		}
+		return c.Next()
+	}
+}
+
+// HasValidSecKey reports whether a request is signed with the active certificate registered
+// for its authId. putCert uses it to let an SAP system replace its own certificate.
+func HasValidSecKey(c *fiber.Ctx, repo *Repository) bool {
+	secKey := c.Query("secKey")
+	if secKey == "" {
+		return false
+	}
+	_, err := verifySecKey(c, repo, secKey, "")
+	return err == nil
+}
+
+// verifySecKey checks the signature, expiration and access mode of a signed URL, with the
+// digest algorithms of the negotiated protocol version, and
+// returns the HTTP status to reply with when the request must be rejected. An empty mode
+// skips the accessMode check.
+func verifySecKey(c *fiber.Ctx, repo *Repository, secKey, mode string) (int, error) {
+	ctx := c.Locals("ctx").(context.Context)
+
+	authID := c.Query("authId")
+	if repo == nil || authID == "" {
+		return http.StatusUnauthorized, errors.New("contRep and authId required")
+	}
+
+	accessMode := c.Query("accessMode")
+	if mode != "" && !strings.Contains(accessMode, mode) {
+		return http.StatusForbidden, fmt.Errorf("accessMode %q does not allow %q", accessMode, mode)
+	}
+
+	expiration, err := time.Parse(expirationLayout, c.Query("expiration"))
+	if err != nil {
+		return http.StatusUnauthorized, fmt.Errorf("invalid expiration: %w", err)
+	}
+	if time.Now().UTC().After(expiration) {
+		return http.StatusForbidden, errors.New("URL expired")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(secKey)
+	if err != nil {
+		return http.StatusUnauthorized, fmt.Errorf("invalid encoding: %w", err)
+	}
+
+	rc, err := LoadCertificate(ctx, repo, authID)
+	if errors.Is(err, ErrCertNotFound) {
+		return http.StatusUnauthorized, fmt.Errorf("no certificate for authId %s", authID)
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !rc.Active {
+		return http.StatusUnauthorized, fmt.Errorf("certificate for authId %s is not active", authID)
+	}
+	if now := time.Now(); now.Before(rc.Cert.NotBefore) || now.After(rc.Cert.NotAfter) {
+		return http.StatusUnauthorized, fmt.Errorf("certificate for authId %s is not valid from %s to %s",
+			authID, rc.Cert.NotBefore.Format(time.RFC3339), rc.Cert.NotAfter.Format(time.RFC3339))
+	}
+
+	if err := VerifyPKCS7(signature, SignedMessage(c), rc.Cert, SignatureDigests(PVersion(c))); err != nil {
+		return http.StatusUnauthorized, err
+	}
+	return 0, nil
+}
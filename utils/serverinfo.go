@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	s3_adapter_config "example.com/s3-multipart-request-adapter/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Server identification reported by serverInfo, overridable at build time with
+// -ldflags "-X example.com/s3-multipart-request-adapter/utils.ServerBuild=..."
+var (
+	ServerVendorID = "S3-multipart-request-adapter"
+	ServerVersion  = "1.0.0"
+	ServerBuild    = "dev"
+)
+
+// Server and repository states reported by serverInfo
+const (
+	ServerStatusRunning  = "running"
+	ContRepStatusRunning = "running"
+	ContRepStorageS3     = "S3"
+)
+
+// CertificateInfo describes a registered certificate in serverInfo
+type CertificateInfo struct {
+	AuthID   string    `json:"authId"`
+	Subject  string    `json:"subject"`
+	NotAfter time.Time `json:"notAfter"`
+	Active   bool      `json:"active"`
+}
+
+// RepositoryInfo describes one content repository in serverInfo
+type RepositoryInfo struct {
+	ContRep      string            `json:"contRep"`
+	Description  string            `json:"contRepDescription"`
+	Status       string            `json:"contRepStatus"`
+	StorageType  string            `json:"storageType"`
+	CertState    string            `json:"certState"`
+	Certificates []CertificateInfo `json:"certificates"`
+
+	Uploader s3_adapter_config.UploaderConfig `json:"uploader"`
+}
+
+// ServerInfo describes the server and its content repositories in ArchiveLink terms
+type ServerInfo struct {
+	VendorID     string           `json:"serverVendorId"`
+	Version      string           `json:"serverVersion"`
+	Build        string           `json:"serverBuild"`
+	Date         string           `json:"serverDate"`
+	Time         string           `json:"serverTime"`
+	Status       string           `json:"serverStatus"`
+	PVersion     string           `json:"pVersion"`
+	PVersions    []string         `json:"supportedPVersions"`
+	Repositories []RepositoryInfo `json:"repositories"`
+}
+
+// LoadServerInfo describes the server and the given content repositories, or all
+// configured repositories when repos is empty
+func LoadServerInfo(ctx context.Context, repos []*Repository) (*ServerInfo, error) {
+	if len(repos) == 0 {
+		repos = Repositories()
+	}
+
+	now := time.Now()
+	info := &ServerInfo{
+		VendorID:  ServerVendorID,
+		Version:   ServerVersion,
+		Build:     ServerBuild,
+		Date:      now.Format(DateLayout),
+		Time:      now.Format(TimeLayout),
+		Status:    ServerStatusRunning,
+		PVersion:  DefaultPVersion,
+		PVersions: SupportedPVersions,
+	}
+
+	for _, repo := range repos {
+		certs, err := ListCertificates(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		state, err := LoadRepositoryState(ctx, repo)
+		if err != nil {
+			return nil, err
+		}
+
+		repoInfo := RepositoryInfo{
+			ContRep:      repo.Name,
+			Description:  repo.Config.Description,
+			Status:       RepoStatus(state),
+			StorageType:  ContRepStorageS3,
+			CertState:    "none",
+			Certificates: make([]CertificateInfo, 0, len(certs)),
+			Uploader:     *repo.Config.Uploader,
+		}
+		for _, rc := range certs {
+			repoInfo.Certificates = append(repoInfo.Certificates, CertificateInfo{
+				AuthID:   rc.AuthID,
+				Subject:  rc.Cert.Subject.String(),
+				NotAfter: rc.Cert.NotAfter,
+				Active:   rc.Active,
+			})
+			if rc.Active {
+				repoInfo.CertState = "active"
+			} else if repoInfo.CertState == "none" {
+				repoInfo.CertState = "inactive"
+			}
+		}
+		info.Repositories = append(info.Repositories, repoInfo)
+	}
+	return info, nil
+}
+
+// Headers returns the server attributes as ArchiveLink response headers
+func (s *ServerInfo) Headers() map[string]string {
+	return map[string]string{
+		"X-serverVendorId":  s.VendorID,
+		"X-serverVersion":   s.Version,
+		"X-serverBuild":     s.Build,
+		"X-serverDate":      s.Date,
+		"X-serverTime":      s.Time,
+		"X-serverStatus":    s.Status,
+		"X-pVersion":        s.PVersion,
+		"X-numberContReps":  strconv.Itoa(len(s.Repositories)),
+		"X-serverPVersions": strings.Join(s.PVersions, ","),
+	}
+}
+
+// repositoryPairs returns the attributes of a repository as key/value pairs
+func (s *ServerInfo) repositoryPairs(repo RepositoryInfo) []string {
+	return []string{
+		"contRep", repo.ContRep,
+		"contRepDescription", repo.Description,
+		"contRepStatus", repo.Status,
+		"contRepStorageType", repo.StorageType,
+		"contRepCertState", repo.CertState,
+		"contRepNumberCerts", strconv.Itoa(len(repo.Certificates)),
+		"uploadPartSize", strconv.FormatInt(repo.Uploader.PartSize, 10),
+		"uploadConcurrency", strconv.Itoa(repo.Uploader.Concurrency),
+		"uploadLeavePartsOnError", strconv.FormatBool(aws.ToBool(repo.Uploader.LeavePartsOnError)),
+		"uploadBufferPoolSize", strconv.Itoa(repo.Uploader.BufferPoolSize),
+		"uploadMaxParts", strconv.Itoa(int(repo.Uploader.MaxUploadParts)),
+		"pVersion", s.PVersion,
+	}
+}
+
+// MultipartBody encodes the repositories as multipart/form-data parts without content
+func (s *ServerInfo) MultipartBody(boundary string) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return nil, err
+	}
+
+	for _, repo := range s.Repositories {
+		header := textproto.MIMEHeader{}
+		pairs := s.repositoryPairs(repo)
+		for i := 0; i+1 < len(pairs); i += 2 {
+			header.Set("X-"+pairs[i], pairs[i+1])
+		}
+		if _, err := mw.CreatePart(header); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ASCIIBody encodes the server (first line) and its repositories (one line each)
+// in the ArchiveLink resultAs=ascii format
+func (s *ServerInfo) ASCIIBody() string {
+	var sb strings.Builder
+	writeASCIILine(&sb,
+		"serverStatus", s.Status,
+		"serverVendorId", s.VendorID,
+		"serverVersion", s.Version,
+		"serverBuild", s.Build,
+		"serverDate", s.Date,
+		"serverTime", s.Time,
+		"pVersion", s.PVersion,
+		"serverPVersions", strings.Join(s.PVersions, ","),
+	)
+	for _, repo := range s.Repositories {
+		writeASCIILine(&sb, s.repositoryPairs(repo)...)
+	}
+	return sb.String()
+}
@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"mime/multipart"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/google/uuid"
+)
+
+// StagingPrefix is the reserved key prefix under which update stages new components
+const StagingPrefix = "_staging/"
+
+// StagedPart is a file part of an update stored under a staging key. CompID and Attrs are
+// set by the caller before the parts are swapped in.
+type StagedPart struct {
+	Key         string // staging key
+	Header      string // X-compId part header
+	FieldName   string
+	Filename    string
+	ContentType string
+	CompID      string
+	Attrs       ObjectAttributes
+}
+
+// Staging holds the components of an update until all of them have arrived, so they can be
+// swapped in together and a failed upload leaves the document unchanged
+type Staging struct {
+	repo    *Repository
+	prefix  string // key prefix of the staged objects
+	Parts   []StagedPart
+	backups []string
+}
+
+// NewStaging starts staging components for an update
+func NewStaging(repo *Repository) *Staging {
+	return &Staging{repo: repo, prefix: repo.Key(StagingPrefix + uuid.New().String() + "/")}
+}
+
+// Stage uploads a file part to the next staging key as it arrives
+func (s *Staging) Stage(ctx context.Context, part *multipart.Part) error {
+	contentType, body, err := ResolveContentType(part.Header.Get("Content-Type"), "", "", part)
+	if err != nil {
+		return err
+	}
+	key := s.prefix + strconv.Itoa(len(s.Parts))
+	if err := UploadFileToS3Stream(ctx, s.repo, s.repo.Uploader, key, body, ObjectAttributes{ContentType: contentType}); err != nil {
+		return err
+	}
+	s.Parts = append(s.Parts, StagedPart{
+		Key:         key,
+		Header:      part.Header.Get("X-compId"),
+		FieldName:   part.FormName(),
+		Filename:    part.FileName(),
+		ContentType: contentType,
+	})
+	return nil
+}
+
+// SwapIn copies the staged parts to their components of a document. existing maps the
+// compIds of the document to their keys. Components replaced in place are backed up first;
+// if a copy fails, they are restored and added components are deleted again. Components
+// larger than 5 GiB cannot be copied with a single CopyObject and fail the update.
+func (s *Staging) SwapIn(ctx context.Context, docID string, existing map[string]string) error {
+	backups := map[string]string{}
+	for _, part := range s.Parts {
+		key := s.repo.ComponentKey(docID, part.CompID)
+		if existing[part.CompID] != key || backups[part.CompID] != "" {
+			continue
+		}
+		backup := s.prefix + "backup/" + part.CompID
+		if err := copyObject(ctx, s.repo, key, backup, nil); err != nil {
+			return err
+		}
+		backups[part.CompID] = backup
+		s.backups = append(s.backups, backup)
+	}
+
+	var swapped []string
+	for _, part := range s.Parts {
+		if err := copyObject(ctx, s.repo, part.Key, s.repo.ComponentKey(docID, part.CompID), &part.Attrs); err != nil {
+			s.restore(docID, swapped, backups)
+			return err
+		}
+		swapped = append(swapped, part.CompID)
+	}
+	return nil
+}
+
+// restore undoes the components swapped in so far: replaced ones get their backup back,
+// added ones are deleted. A legacy object was never touched, so it reappears by itself.
+func (s *Staging) restore(docID string, swapped []string, backups map[string]string) {
+	ctx := context.Background()
+	for _, compID := range swapped {
+		key := s.repo.ComponentKey(docID, compID)
+		var err error
+		if backup, ok := backups[compID]; ok {
+			err = copyObject(ctx, s.repo, backup, key, nil)
+		} else {
+			_, err = s.repo.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(s.repo.Bucket),
+				Key:    aws.String(key),
+			})
+		}
+		if err != nil {
+			log.Printf("Failed to restore %s after a failed update: %v", key, err)
+		}
+	}
+}
+
+// Remove deletes the staged parts and backups. It also runs when the request was cancelled;
+// objects it cannot delete are removed later by RemoveStaleStaging.
+func (s *Staging) Remove() {
+	ids := make([]types.ObjectIdentifier, 0, len(s.Parts)+len(s.backups))
+	for _, part := range s.Parts {
+		ids = append(ids, types.ObjectIdentifier{Key: aws.String(part.Key)})
+	}
+	for _, backup := range s.backups {
+		ids = append(ids, types.ObjectIdentifier{Key: aws.String(backup)})
+	}
+	if len(ids) == 0 {
+		return
+	}
+	_, err := s.repo.Client.DeleteObjects(context.Background(), &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.repo.Bucket),
+		Delete: &types.Delete{Objects: ids, Quiet: aws.Bool(true)},
+	})
+	if err != nil {
+		log.Printf("Failed to remove staged update %s: %v", s.prefix, err)
+	}
+}
+
+// RemoveStaleStaging deletes staged objects of a repository last modified before cutoff,
+// left behind by a process that stopped during an update, and returns how many were deleted
+func RemoveStaleStaging(ctx context.Context, repo *Repository, cutoff time.Time) (int, error) {
+	removed := 0
+	paginator := s3.NewListObjectsV2Paginator(repo.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(repo.Bucket),
+		Prefix: aws.String(repo.Key(StagingPrefix)),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return removed, err
+		}
+		for _, obj := range page.Contents {
+			if !aws.ToTime(obj.LastModified).Before(cutoff) {
+				continue
+			}
+			_, err := repo.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+				Bucket: aws.String(repo.Bucket),
+				Key:    obj.Key,
+			})
+			if err != nil {
+				return removed, err
+			}
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// copyObject copies an object server-side, replacing its attributes when attrs is set and
+// keeping them otherwise
+func copyObject(ctx context.Context, repo *Repository, src, dst string, attrs *ObjectAttributes) error {
+	input := &s3.CopyObjectInput{
+		Bucket:       aws.String(repo.Bucket),
+		Key:          aws.String(dst),
+		CopySource:   aws.String(url.PathEscape(repo.Bucket + "/" + src)),
+		StorageClass: types.StorageClass(repo.Config.StorageClass),
+	}
+	if attrs != nil {
+		input.ContentType = aws.String(attrs.ContentType)
+		input.Metadata = attrs.Metadata
+		input.MetadataDirective = types.MetadataDirectiveReplace
+		input.Tagging = aws.String(EncodeTags(attrs.Tags))
+		input.TaggingDirective = types.TaggingDirectiveReplace
+	}
+	_, err := repo.Client.CopyObject(ctx, input)
+	return err
+}
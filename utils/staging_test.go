@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// copyBackend emulates an S3 bucket that copies, deletes and batch deletes objects and fails
+// copies to one key
+type copyBackend struct {
+	mu       sync.Mutex
+	objects  map[string]string
+	failCopy string
+}
+
+func (b *copyBackend) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	key := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/"), "bucket/")
+	w.Header().Set("Content-Type", "application/xml")
+	switch {
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		source, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+		data, ok := b.objects[strings.TrimPrefix(source, "bucket/")]
+		if !ok || key == b.failCopy {
+			w.WriteHeader(http.StatusForbidden)
+			io.WriteString(w, `<Error><Code>AccessDenied</Code><Message>copy failed</Message></Error>`)
+			return
+		}
+		b.objects[key] = data
+		io.WriteString(w, `<CopyObjectResult><ETag>"copy"</ETag></CopyObjectResult>`)
+	case r.Method == http.MethodDelete:
+		delete(b.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+		var request struct {
+			Objects []struct{ Key string } `xml:"Object"`
+		}
+		xml.Unmarshal(body, &request)
+		for _, obj := range request.Objects {
+			delete(b.objects, obj.Key)
+		}
+		io.WriteString(w, `<DeleteResult></DeleteResult>`)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestSwapInRestoresOnFailure(t *testing.T) {
+	backend := &copyBackend{objects: map[string]string{"DOC/data": "OLD DATA"}, failCopy: "DOC/note"}
+	repo := newTestRepository(t, "staging", backend)
+
+	staging := NewStaging(repo)
+	for _, compID := range []string{"data", "note"} {
+		part := StagedPart{Key: staging.prefix + compID, CompID: compID, Attrs: ObjectAttributes{ContentType: "text/plain"}}
+		backend.objects[part.Key] = "NEW " + compID
+		staging.Parts = append(staging.Parts, part)
+	}
+
+	existing := map[string]string{"data": "DOC/data"}
+	if err := staging.SwapIn(context.Background(), "DOC", existing); err == nil {
+		t.Fatalf("Expected the swap to fail")
+	}
+	if backend.objects["DOC/data"] != "OLD DATA" {
+		t.Fatalf("Expected the replaced component to be restored, got %q", backend.objects["DOC/data"])
+	}
+	if _, ok := backend.objects["DOC/note"]; ok {
+		t.Fatalf("Expected the added component to be missing")
+	}
+
+	staging.Remove()
+	if len(backend.objects) != 1 {
+		t.Fatalf("Expected the staged parts and backups to be removed, got %v", backend.objects)
+	}
+}
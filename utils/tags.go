@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Limits S3 places on the tag set of an object
+const (
+	MaxObjectTags     = 10
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
+)
+
+// TagHeaderPrefix is the prefix of request headers carrying extra tags: X-tag-<name>: <value>
+const TagHeaderPrefix = "X-tag-"
+
+// AnyTag in the allowedTags of a content repository lets clients set tags of any name
+const AnyTag = "*"
+
+// ErrInvalidTags is returned when a tag set breaks the S3 limits or the repository policy
+var ErrInvalidTags = errors.New("invalid tags")
+
+// systemTags are the tags written by the adapter itself, see ComponentMeta.Tags
+var systemTags = []string{"contRep", "docId", "compId"}
+
+// EncodeTags converts a key-value map into the URL query encoded form expected by the
+// S3 Tagging header. Keys are sorted so the encoding is stable.
+func EncodeTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte('&')
+		}
+		sb.WriteString(escapeTag(k))
+		sb.WriteByte('=')
+		sb.WriteString(escapeTag(tags[k]))
+	}
+	return sb.String()
+}
+
+// escapeTag percent-encodes a tag key or value; spaces are sent as %20 rather than '+'
+func escapeTag(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+// ValidateTags checks a tag set against the S3 limits
+func ValidateTags(tags map[string]string) error {
+	if len(tags) > MaxObjectTags {
+		return fmt.Errorf("%w: %d tags, at most %d allowed", ErrInvalidTags, len(tags), MaxObjectTags)
+	}
+	for k, v := range tags {
+		switch {
+		case k == "":
+			return fmt.Errorf("%w: empty tag name", ErrInvalidTags)
+		case strings.HasPrefix(strings.ToLower(k), "aws:"):
+			return fmt.Errorf("%w: tag %q uses the reserved aws: prefix", ErrInvalidTags, k)
+		case utf8.RuneCountInString(k) > MaxTagKeyLength:
+			return fmt.Errorf("%w: tag name %q longer than %d characters", ErrInvalidTags, k, MaxTagKeyLength)
+		case utf8.RuneCountInString(v) > MaxTagValueLength:
+			return fmt.Errorf("%w: value of tag %q longer than %d characters", ErrInvalidTags, k, MaxTagValueLength)
+		}
+	}
+	return nil
+}
+
+// RequestTags returns the extra tags sent as X-tag-* request headers. Tag names must be
+// listed in the allowedTags of the repository, whose spelling is used; with AnyTag any
+// name is accepted and stored in lower case.
+func RequestTags(c *fiber.Ctx, repo *Repository) (map[string]string, error) {
+	tags := map[string]string{}
+	var err error
+	c.Request().Header.VisitAll(func(key, value []byte) {
+		name := string(key)
+		if err != nil || len(name) <= len(TagHeaderPrefix) || !strings.EqualFold(name[:len(TagHeaderPrefix)], TagHeaderPrefix) {
+			return
+		}
+		name = strings.ToLower(name[len(TagHeaderPrefix):])
+
+		allowed := ""
+		for _, t := range repo.Config.AllowedTags {
+			if strings.EqualFold(t, name) {
+				allowed = t
+				break
+			}
+			if t == AnyTag {
+				allowed = name
+			}
+		}
+		if allowed == "" || isSystemTag(allowed) {
+			err = fmt.Errorf("%w: tag %q is not allowed in content repository %s", ErrInvalidTags, name, repo.Name)
+			return
+		}
+		tags[allowed] = string(value)
+	})
+	return tags, err
+}
+
+// ComponentTags returns the complete, validated tag set of a component: the system tags
+// of its attributes plus the extra tags
+func ComponentTags(meta ComponentMeta, extra map[string]string) (map[string]string, error) {
+	tags := meta.Tags()
+	for k, v := range extra {
+		if !isSystemTag(k) {
+			tags[k] = v
+		}
+	}
+	return tags, ValidateTags(tags)
+}
+
+// ExtraTags returns the tags of a tag set that were not written by the adapter itself
+func ExtraTags(tags map[string]string) map[string]string {
+	extra := map[string]string{}
+	for k, v := range tags {
+		if !isSystemTag(k) && !isLegacyTag(k) {
+			extra[k] = v
+		}
+	}
+	return extra
+}
+
+// isSystemTag reports whether a tag name is reserved for the adapter
+func isSystemTag(name string) bool {
+	return slices.ContainsFunc(systemTags, func(t string) bool { return strings.EqualFold(t, name) })
+}
+
+// isLegacyTag reports whether a tag name held document attributes before they moved to
+// user metadata; such tags are dropped when a component is rewritten
+func isLegacyTag(name string) bool {
+	switch name {
+	case "filename", "X-dateC", "X-timeC", "X-dateM", "X-timeM":
+		return true
+	}
+	return false
+}
+
+// GetObjectTags returns the tags of an S3 object as a key-value map
+func GetObjectTags(ctx context.Context, repo *Repository, key string) (map[string]string, error) {
+	out, err := repo.Client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(repo.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(out.TagSet))
+	for _, t := range out.TagSet {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+	return tags, nil
+}
+
+// PutObjectTags replaces the tags of an S3 object
+func PutObjectTags(ctx context.Context, repo *Repository, key string, tags map[string]string) error {
+	set := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		set = append(set, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := repo.Client.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(repo.Bucket),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: set},
+	})
+	return err
+}
+
+// WithExtraTags returns a tag set with the extra tags of tags replaced by extra. Tags the
+// adapter wrote itself are kept.
+func WithExtraTags(tags, extra map[string]string) (map[string]string, error) {
+	merged := make(map[string]string, len(tags)+len(extra))
+	for k, v := range tags {
+		if isSystemTag(k) || isLegacyTag(k) {
+			merged[k] = v
+		}
+	}
+	for k, v := range extra {
+		merged[k] = v
+	}
+	return merged, ValidateTags(merged)
+}